@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"unicode/utf8"
+
+	"github.com/apex/log"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/pressly/chi/render"
+	"github.com/ulule/limiter/drivers/middleware/stdlib"
+)
+
+// maxAPIContent is the size of the largest paste whose content is included in
+// its JSON. Larger pastes are read from their raw endpoint
+const maxAPIContent = 1 * Megabyte
+
+// maxAPIRequest is the size of the largest JSON request, which is decoded whole
+// into memory. It leaves room for a paste of maxAPIContent to be base64 encoded;
+// larger pastes are sent as their exact bytes, which are streamed into the store
+const maxAPIRequest = 2 * maxAPIContent
+
+const (
+	// EncodingText is the encoding of content which is sent as it is
+	EncodingText = "utf-8"
+	// EncodingBase64 is the encoding of content which is not UTF-8 text and is
+	// sent base64 encoded instead
+	EncodingBase64 = "base64"
+)
+
+var (
+	// ErrContentTooLarge is an error indicating a paste is too large to be
+	// included in its JSON
+	ErrContentTooLarge = fmt.Errorf("pastes larger than %d bytes must be read from /api/v1/pastes/{name}/raw", maxAPIContent)
+	// ErrRequestTooLarge is an error indicating a JSON request is too large to
+	// be decoded
+	ErrRequestTooLarge = fmt.Errorf("JSON requests larger than %d bytes are refused, send larger pastes as their exact bytes with the options in the query string", maxAPIRequest)
+	// ErrRateLimited is an error indicating too many pastes were created
+	ErrRateLimited = fmt.Errorf("too many pastes created, try again later")
+	// ErrNoRoute is an error indicating there is no such API endpoint
+	ErrNoRoute = fmt.Errorf("no such endpoint")
+	// ErrMethod is an error indicating an API endpoint does not accept the
+	// method it was requested with
+	ErrMethod = fmt.Errorf("method not allowed")
+	// ErrNoOwnerToken is an error indicating pastes were listed without saying
+	// whose
+	ErrNoOwnerToken = fmt.Errorf("the owner token pastes were created with must be given in the X-Owner-Token header")
+)
+
+// errorCodes are the codes given to API errors by their status
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal",
+}
+
+// CreatePasteRequest is the JSON body which creates a paste through the API.
+// Pastes may also be created by sending their exact bytes with any other
+// content type, with the same options given in the query string or headers
+type CreatePasteRequest struct {
+	// Content is the paste, encoded as Encoding says
+	Content string `json:"content"`
+	// Encoding is EncodingBase64 for content which is base64 encoded. Content
+	// is otherwise taken as it is
+	Encoding string `json:"encoding,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	Language string `json:"language,omitempty"`
+	TTL      string `json:"ttl,omitempty"`
+	Burn     bool   `json:"burn,omitempty"`
+	Password string `json:"password,omitempty"`
+	Editable bool   `json:"editable,omitempty"`
+	Format   string `json:"format,omitempty"`
+	// OwnerToken lists the paste with the others created with the same
+	// token. A new token is made if it is empty
+	OwnerToken string `json:"owner_token,omitempty"`
+}
+
+// form returns the options of the request as the fields of the html form, so
+// they are read and checked the same way
+func (req *CreatePasteRequest) form() url.Values {
+	form := url.Values{}
+	for field, v := range map[string]string{
+		"name":        req.Name,
+		"filename":    req.Filename,
+		"lang":        req.Language,
+		"ttl":         req.TTL,
+		"password":    req.Password,
+		"format":      req.Format,
+		"owner_token": req.OwnerToken,
+	} {
+		if v != "" {
+			form.Set(field, v)
+		}
+	}
+	if req.Burn {
+		form.Set("burn", "1")
+	}
+	if req.Editable {
+		form.Set("edit", "1")
+	}
+	return form
+}
+
+// content decodes the content of the paste
+func (req *CreatePasteRequest) content() ([]byte, error) {
+	switch req.Encoding {
+	case "", EncodingText:
+		return []byte(req.Content), nil
+	case EncodingBase64:
+		b, err := base64.StdEncoding.DecodeString(req.Content)
+		if err != nil {
+			return nil, fmt.Errorf("content is not valid base64")
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("unknown encoding %q", req.Encoding)
+}
+
+// Paste describes a paste in responses from the API
+type Paste struct {
+	*PasteInfo
+	URL    string `json:"url"`
+	RawURL string `json:"raw_url"`
+	// Content and Encoding are only set when the paste itself was requested
+	Content  string `json:"content,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// CreatedPaste is the response to creating a paste, along with the tokens
+// which manage it. The delete and edit tokens are only ever given out once
+type CreatedPaste struct {
+	Paste
+	DeleteToken string `json:"delete_token"`
+	EditToken   string `json:"edit_token,omitempty"`
+	// OwnerToken lists the paste, and is given when creating more pastes to
+	// list them together
+	OwnerToken string `json:"owner_token"`
+}
+
+// PasteList is the response listing the pastes created with an owner token
+type PasteList struct {
+	Pastes []Paste `json:"pastes"`
+}
+
+// ErrorResponse is the body of every error response from the API
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// APIError describes why an API request failed
+type APIError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// registerAPIRoutes registers the routes of the JSON API under /api/v1
+func (h *Handler) registerAPIRoutes(mux chi.Router) {
+	mux.Route("/api/v1", func(r chi.Router) {
+		r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+			sendAPIError(w, http.StatusNotFound, ErrNoRoute)
+		})
+		r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+			sendAPIError(w, http.StatusMethodNotAllowed, ErrMethod)
+		})
+
+		r.Get("/openapi.json", h.getOpenAPI)
+		r.With(ipRateLimiter(h.limiter, stdlib.WithLimitReachedHandler(func(w http.ResponseWriter, r *http.Request) {
+			sendAPIError(w, http.StatusTooManyRequests, ErrRateLimited)
+		}))).Post("/pastes", h.apiCreatePaste)
+		r.Get("/pastes", h.apiListPastes)
+		r.Get("/pastes/{name}", h.apiGetPaste)
+		r.Delete("/pastes/{name}", h.apiDeletePaste)
+		r.Get("/pastes/{name}/info", h.apiGetInfo)
+		r.Get("/pastes/{name}/raw", h.apiGetRaw)
+	})
+}
+
+// getOpenAPI serves the OpenAPI document describing the API
+func (h *Handler) getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, openAPIDocument)
+}
+
+// apiCreatePaste creates a paste from a CreatePasteRequest, or from the exact
+// bytes of the body if it is not JSON
+func (h *Handler) apiCreatePaste(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	up, limited, ok := h.apiReceive(w, r, mediaType)
+	if !ok {
+		return
+	}
+	defer up.close()
+
+	// every paste made through the API can be listed, so one which does not
+	// join the pastes of an existing owner token is given a new token
+	up.owner = option(r, up.form, "owner_token", "X-Owner-Token")
+	if up.owner == "" {
+		var err error
+		if up.owner, _, err = newToken(); err != nil {
+			sendAPIError(w, 500, errors.Wrap(err, "failed to generate owner token"))
+			return
+		}
+	}
+
+	p, status, err := h.createPaste(r, up, limited)
+	if err != nil {
+		sendAPIError(w, status, err)
+		return
+	}
+
+	w.Header().Set("Location", absoluteURL(r, "/api/v1/pastes/"+p.name))
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, &CreatedPaste{
+		Paste:       newAPIPaste(r, p.info()),
+		DeleteToken: p.token,
+		EditToken:   p.editToken,
+		OwnerToken:  p.owner,
+	})
+}
+
+// apiReceive reads the paste being created through the API, responding with an
+// error if it is too large or cannot be read
+func (h *Handler) apiReceive(w http.ResponseWriter, r *http.Request, mediaType string) (*upload, *limitedBody, bool) {
+	if mediaType != "application/json" {
+		if r.ContentLength > h.maxSize {
+			sendAPIError(w, http.StatusRequestEntityTooLarge, ErrTooLarge)
+			return nil, nil, false
+		}
+		limited := newLimitedBody(r.Body, h.maxSize)
+		body, rest, err := readHead(limited)
+		if limited.exceeded() {
+			sendAPIError(w, http.StatusRequestEntityTooLarge, ErrTooLarge)
+			return nil, nil, false
+		}
+		if err != nil {
+			sendAPIError(w, http.StatusBadRequest, errors.Wrap(err, "failed to read body"))
+			return nil, nil, false
+		}
+		return &upload{body: body, rest: rest, contentType: mediaType}, limited, true
+	}
+
+	if r.ContentLength > maxAPIRequest {
+		sendAPIError(w, http.StatusRequestEntityTooLarge, ErrRequestTooLarge)
+		return nil, nil, false
+	}
+	limited := newLimitedBody(r.Body, maxAPIRequest)
+	var req CreatePasteRequest
+	err := json.NewDecoder(limited).Decode(&req)
+	if limited.exceeded() {
+		sendAPIError(w, http.StatusRequestEntityTooLarge, ErrRequestTooLarge)
+		return nil, nil, false
+	}
+	if err != nil {
+		sendAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %s", err))
+		return nil, nil, false
+	}
+	content, err := req.content()
+	if err != nil {
+		sendAPIError(w, http.StatusBadRequest, err)
+		return nil, nil, false
+	}
+	if int64(len(content)) > h.maxSize {
+		sendAPIError(w, http.StatusRequestEntityTooLarge, ErrTooLarge)
+		return nil, nil, false
+	}
+
+	body, rest, err := readHead(ioutil.NopCloser(bytes.NewReader(content)))
+	if err != nil {
+		sendAPIError(w, 500, err)
+		return nil, nil, false
+	}
+	return &upload{body: body, rest: rest, form: req.form()}, limited, true
+}
+
+// apiListPastes lists the pastes which have not expired created with the owner
+// token in the X-Owner-Token header
+func (h *Handler) apiListPastes(w http.ResponseWriter, r *http.Request) {
+	owner := r.Header.Get("X-Owner-Token")
+	if owner == "" {
+		sendAPIError(w, http.StatusBadRequest, ErrNoOwnerToken)
+		return
+	}
+	infos, err := h.store.List(ownerCreator(owner))
+	if err != nil {
+		sendAPIError(w, 500, err)
+		return
+	}
+	list := PasteList{Pastes: make([]Paste, len(infos))}
+	for i, info := range infos {
+		list.Pastes[i] = newAPIPaste(r, info)
+	}
+	render.JSON(w, r, &list)
+}
+
+// apiGetPaste serves a paste and its content. Content which is not UTF-8 text
+// is base64 encoded
+func (h *Handler) apiGetPaste(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	info, ok := h.apiAuthorize(w, r, name)
+	if !ok {
+		return
+	}
+	if info.Size > maxAPIContent {
+		sendAPIError(w, http.StatusRequestEntityTooLarge, ErrContentTooLarge)
+		return
+	}
+
+	body, info, err := h.store.Get(name)
+	if err == ErrNotFound {
+		sendAPIError(w, 404, ErrNotFound)
+		return
+	}
+	if err != nil {
+		sendAPIError(w, 500, err)
+		return
+	}
+
+	p := newAPIPaste(r, info)
+	if utf8.Valid(body) {
+		p.Content, p.Encoding = string(body), EncodingText
+	} else {
+		p.Content, p.Encoding = base64.StdEncoding.EncodeToString(body), EncodingBase64
+	}
+	render.JSON(w, r, &p)
+}
+
+// apiGetInfo serves the metadata of a paste without reading it
+func (h *Handler) apiGetInfo(w http.ResponseWriter, r *http.Request) {
+	info, err := h.store.Stat(chi.URLParam(r, "name"))
+	if err == ErrNotFound {
+		sendAPIError(w, 404, ErrNotFound)
+		return
+	}
+	if err != nil {
+		sendAPIError(w, 500, err)
+		return
+	}
+	p := newAPIPaste(r, info)
+	render.JSON(w, r, &p)
+}
+
+// apiGetRaw serves the exact bytes of a paste of any size
+func (h *Handler) apiGetRaw(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if _, ok := h.apiAuthorize(w, r, name); !ok {
+		return
+	}
+	stream, info, err := h.store.GetChunks(name)
+	if err == ErrNotFound {
+		sendAPIError(w, 404, ErrNotFound)
+		return
+	}
+	if err != nil {
+		sendAPIError(w, 500, err)
+		return
+	}
+	streamPaste(w, stream, info)
+}
+
+// apiDeletePaste removes a paste before it expires. The delete token must be
+// given in the X-Delete-Token header or the token query parameter
+func (h *Handler) apiDeletePaste(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	info, err := h.store.Stat(name)
+	if err == ErrNotFound {
+		sendAPIError(w, 404, ErrNotFound)
+		return
+	}
+	if err != nil {
+		sendAPIError(w, 500, err)
+		return
+	}
+
+	token := r.Header.Get("X-Delete-Token")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if !checkToken(token, info.DeleteTokenHash) {
+		sendAPIError(w, 403, ErrForbidden)
+		return
+	}
+
+	if err := h.store.Delete(name); err != nil && err != ErrNotFound {
+		sendAPIError(w, 500, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiAuthorize checks the password of a protected paste like authorize but
+// responds with API errors
+func (h *Handler) apiAuthorize(w http.ResponseWriter, r *http.Request, name string) (*PasteInfo, bool) {
+	info, err := h.store.Stat(name)
+	if err == ErrNotFound {
+		sendAPIError(w, 404, ErrNotFound)
+		return nil, false
+	}
+	if err != nil {
+		sendAPIError(w, 500, err)
+		return nil, false
+	}
+	if info.PasswordHash == "" {
+		return info, true
+	}
+	ok, err := h.verifyPassword(r.RemoteAddr, name, requestPassword(r), info.PasswordHash)
+	if err == ErrPasswordAttempts {
+		sendAPIError(w, http.StatusTooManyRequests, err)
+		return nil, false
+	}
+	if err != nil {
+		sendAPIError(w, 500, err)
+		return nil, false
+	}
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="icanhazpaste"`)
+		sendAPIError(w, http.StatusUnauthorized, ErrUnauthorized)
+		return nil, false
+	}
+	return info, true
+}
+
+// newAPIPaste describes a paste for the API without its content
+func newAPIPaste(r *http.Request, info *PasteInfo) Paste {
+	return Paste{
+		PasteInfo: info,
+		URL:       newURL(r, info.Name),
+		RawURL:    absoluteURL(r, "/raw/"+info.Name),
+	}
+}
+
+// sendAPIError responds with an ErrorResponse. Like sendError, the messages of
+// internal errors are only shown in debug mode
+func sendAPIError(w http.ResponseWriter, status int, err error) {
+	msg := err.Error()
+	if status > 499 {
+		log.WithError(err).Error(msg)
+		if !debug {
+			msg = ErrInternal.Error()
+		}
+	}
+	code, ok := errorCodes[status]
+	if !ok {
+		code = errorCodes[http.StatusInternalServerError]
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&ErrorResponse{Error: APIError{
+		Status:  status,
+		Code:    code,
+		Message: msg,
+	}})
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/blockloop/icanhazpaste/highlight"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/pressly/chi/render"
+)
+
+const (
+	// maxBundleFiles is the most files a bundle may hold
+	maxBundleFiles = 100
+	// maxBundleView is the size of the largest file in a bundle which is shown
+	// in its HTML page. Larger files are only linked to
+	maxBundleView = 256 * Kilobyte
+)
+
+var (
+	// ErrBundleFormat is an error indicating a bundle was given a format
+	ErrBundleFormat = fmt.Errorf("bundles cannot be given a format")
+	// ErrNotBundle is an error indicating the bundle format was given for an
+	// upload which is not a bundle
+	ErrNotBundle = fmt.Errorf(`only multipart forms and JSON of the form {"files": [...]} can be bundles`)
+	// ErrBurnBundle is an error indicating a bundle was asked to burn. Its
+	// files are read one at a time, so the first would destroy the rest
+	ErrBurnBundle = fmt.Errorf("bundles cannot burn after reading")
+	// ErrBundleMismatch is an error indicating a revision was not of the same
+	// kind as the paste it was added to
+	ErrBundleMismatch = fmt.Errorf("bundles can only be revised with bundles and other pastes only without them")
+)
+
+// reservedFileNames are the names of the other routes under a paste which
+// would hide files of the same name in a bundle
+var reservedFileNames = map[string]bool{
+	"info":    true,
+	"history": true,
+	"fork":    true,
+	"v":       true,
+}
+
+// bundleFile is a named file uploaded as part of a bundle
+type bundleFile struct {
+	name string
+	size int64
+	open func() (io.ReadCloser, error)
+}
+
+// parseBundleJSON returns the files of a bundle sent as JSON in the form
+// {"files": [{"name": "Dockerfile", "content": "FROM scratch"}]}, or nil if
+// body is not a bundle
+func parseBundleJSON(body []byte) []bundleFile {
+	var req struct {
+		Files []struct {
+			Name    *string `json:"name"`
+			Content *string `json:"content"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || len(req.Files) == 0 {
+		return nil
+	}
+
+	files := make([]bundleFile, len(req.Files))
+	for i, f := range req.Files {
+		if f.Name == nil || f.Content == nil {
+			return nil
+		}
+		content := []byte(*f.Content)
+		files[i] = bundleFile{
+			name: cleanFilename(*f.Name),
+			size: int64(len(content)),
+			open: func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(content)), nil
+			},
+		}
+	}
+	return files
+}
+
+// checkBundleFiles returns an error if the files cannot be stored together as
+// a bundle
+func checkBundleFiles(files []bundleFile) error {
+	if len(files) > maxBundleFiles {
+		return fmt.Errorf("bundles may hold at most %d files", maxBundleFiles)
+	}
+	seen := make(map[string]bool, len(files))
+	for _, f := range files {
+		switch {
+		case f.name == "" || f.name == "..":
+			return fmt.Errorf("every file in a bundle must have a name")
+		case reservedFileNames[f.name]:
+			return fmt.Errorf("%q cannot be used as the name of a file in a bundle", f.name)
+		case seen[f.name]:
+			return fmt.Errorf("%q is the name of more than one file in the bundle", f.name)
+		}
+		seen[f.name] = true
+	}
+	return nil
+}
+
+// setBundle makes files the body of the upload as a tar archive, which is
+// written as it is read
+func (up *upload) setBundle(files []bundleFile) error {
+	if err := checkBundleFiles(files); err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeBundle(pw, files))
+	}()
+	body, rest, err := readHead(pr)
+	if err != nil {
+		pr.Close()
+		return err
+	}
+	up.body, up.rest, up.bundle = body, rest, true
+	return nil
+}
+
+// writeBundle writes files to w as a tar archive
+func writeBundle(w io.Writer, files []bundleFile) error {
+	tw := tar.NewWriter(w)
+	now := time.Now().UTC()
+	for _, f := range files {
+		rc, err := f.open()
+		if err != nil {
+			return errors.Wrap(err, "failed to open uploaded file")
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Name:     f.name,
+			Mode:     0644,
+			Size:     f.size,
+			ModTime:  now,
+			Typeflag: tar.TypeReg,
+		})
+		if err == nil {
+			_, err = io.Copy(tw, rc)
+		}
+		rc.Close()
+		if err != nil {
+			return errors.Wrap(err, "failed to write bundle")
+		}
+	}
+	return tw.Close()
+}
+
+// openBundle reads the latest revision of a bundle, or the given revision, as
+// a tar archive once the request is authorized. The stream must be closed once
+// the archive has been read
+func (h *Handler) openBundle(w http.ResponseWriter, r *http.Request, name string, rev int) (*tar.Reader, ChunkStream, *PasteInfo, bool) {
+	info, ok := h.authorize(w, r, name, render.GetAcceptedContentType(r) == render.ContentTypeHTML)
+	if !ok {
+		return nil, nil, nil, false
+	}
+	if info.Format != FormatBundle || rev > info.Revision {
+		sendError(w, 404, ErrNotFound)
+		return nil, nil, nil, false
+	}
+	return h.readBundle(w, name, rev)
+}
+
+// readBundle reads a revision of a bundle as a tar archive, responding with an
+// error if it cannot be read
+func (h *Handler) readBundle(w http.ResponseWriter, name string, rev int) (*tar.Reader, ChunkStream, *PasteInfo, bool) {
+	stream, info, err := h.store.GetRevision(name, rev)
+	if err == ErrNotFound {
+		sendError(w, 404, ErrNotFound)
+		return nil, nil, nil, false
+	}
+	if err != nil {
+		sendError(w, 500, err)
+		return nil, nil, nil, false
+	}
+	return tar.NewReader(&chunkReader{stream: stream}), stream, info, true
+}
+
+// getBundleFile serves the exact bytes of one file in the latest revision of
+// a bundle, or the revision named in the URL
+func (h *Handler) getBundleFile(w http.ResponseWriter, r *http.Request) {
+	name, file := chi.URLParam(r, "name"), chi.URLParam(r, "file")
+	rev, err := revisionParam(r)
+	if err != nil {
+		sendError(w, 404, ErrNotFound)
+		return
+	}
+	tr, stream, info, ok := h.openBundle(w, r, name, rev)
+	if !ok {
+		return
+	}
+	defer stream.Close()
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			sendError(w, 404, ErrNotFound)
+			return
+		}
+		if err != nil {
+			sendError(w, 500, errors.Wrap(err, "failed to read bundle"))
+			return
+		}
+		if hdr.Name != file {
+			continue
+		}
+
+		// the type of the file is detected from its name and first bytes
+		head := make([]byte, 512)
+		n, err := io.ReadFull(tr, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			sendError(w, 500, errors.Wrap(err, "failed to read bundle"))
+			return
+		}
+		head = head[:n]
+		fileInfo := &PasteInfo{
+			Name:        name,
+			Filename:    file,
+			ContentType: detectContentType("", file, head),
+		}
+		w.Header().Set("Expires", info.Expires.Format(time.RFC1123))
+		writeRaw(w, head, fileInfo)
+		if _, err := io.Copy(w, tr); err != nil {
+			log.WithError(err).WithField("name", name).Error("failed to stream bundle file")
+		}
+		return
+	}
+}
+
+// getBundleTarGz serves a bundle as a gzip compressed tar archive
+func (h *Handler) getBundleTarGz(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	_, stream, _, ok := h.openBundle(w, r, name, 0)
+	if !ok {
+		return
+	}
+	defer stream.Close()
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", attachment(name+".tar.gz"))
+	zw := gzip.NewWriter(w)
+	err := writeChunks(zw, stream)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		log.WithError(err).WithField("name", name).Error("failed to stream bundle")
+	}
+}
+
+// getBundleZip serves a bundle as a zip archive
+func (h *Handler) getBundleZip(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	tr, stream, _, ok := h.openBundle(w, r, name, 0)
+	if !ok {
+		return
+	}
+	defer stream.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", attachment(name+".zip"))
+	zw := zip.NewWriter(w)
+	err := func() error {
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return zw.Close()
+			}
+			if err != nil {
+				return err
+			}
+			fh := &zip.FileHeader{Name: hdr.Name, Method: zip.Deflate}
+			fh.SetModTime(hdr.ModTime)
+			f, err := zw.CreateHeader(fh)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				return err
+			}
+		}
+	}()
+	if err != nil {
+		// the response has started so all that can be done is to cut it short
+		log.WithError(err).WithField("name", name).Error("failed to stream bundle")
+	}
+}
+
+// bundleView is a file of a bundle as it is shown on its HTML page
+type bundleView struct {
+	Name string
+	// Path is where the exact bytes of the file are served from
+	Path  string
+	Size  int64
+	Lines []pasteLine
+	// Shown is false for files which are too large or are not text
+	Shown bool
+}
+
+// serveBundleHTML renders a revision of a bundle as an HTML page with a tab for
+// each file. Files which are large or not text are only linked to
+func (h *Handler) serveBundleHTML(w http.ResponseWriter, r *http.Request, name string, rev int) {
+	tr, stream, info, ok := h.readBundle(w, name, rev)
+	if !ok {
+		return
+	}
+	defer stream.Close()
+
+	var files []bundleView
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			sendError(w, 500, errors.Wrap(err, "failed to read bundle"))
+			return
+		}
+
+		view := bundleView{Name: hdr.Name, Size: hdr.Size}
+		if hdr.Size <= maxBundleView {
+			var buf bytes.Buffer
+			if _, err := io.Copy(&buf, tr); err != nil {
+				sendError(w, 500, errors.Wrap(err, "failed to read bundle"))
+				return
+			}
+			if text := buf.String(); isText(detectContentType("", hdr.Name, buf.Bytes())) {
+				lang := highlight.Lookup(filepath.Ext(hdr.Name))
+				if lang == nil {
+					lang = highlight.Detect(text)
+				}
+				for i, l := range highlight.Lines(lang, text) {
+					view.Lines = append(view.Lines, pasteLine{Number: i + 1, HTML: l})
+				}
+				view.Shown = true
+			}
+		}
+		files = append(files, view)
+	}
+	renderBundleHTML(w, r, files, info)
+}
+
+// attachment formats a Content-Disposition header which downloads a file
+func attachment(filename string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
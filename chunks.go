@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"io"
+)
+
+// ChunkSource supplies the body of a paste which is being stored one chunk at a
+// time
+type ChunkSource interface {
+	// Next returns the next chunk of the body, or io.EOF after the last one
+	Next() ([]byte, error)
+	// Size returns the length of the body as it was sent. It is only final
+	// once Next has returned io.EOF
+	Size() int64
+}
+
+// ChunkStream streams the body of a stored paste one chunk at a time
+type ChunkStream interface {
+	// Next returns the next chunk of the body, or io.EOF after the last one
+	Next() ([]byte, error)
+	// Close releases the stream. It must be called once the stream is no
+	// longer needed
+	Close() error
+}
+
+// bytesChunks is a ChunkSource and ChunkStream of a body which is already in
+// memory. The body is a single chunk
+type bytesChunks struct {
+	body []byte
+	done bool
+}
+
+func newBytesChunks(body []byte) *bytesChunks {
+	return &bytesChunks{body: body}
+}
+
+func (c *bytesChunks) Next() ([]byte, error) {
+	if c.done {
+		return nil, io.EOF
+	}
+	c.done = true
+	return c.body, nil
+}
+
+func (c *bytesChunks) Size() int64 {
+	return int64(len(c.body))
+}
+
+func (c *bytesChunks) Close() error {
+	return nil
+}
+
+// sliceChunks is a ChunkStream of chunks which are already in memory
+type sliceChunks struct {
+	chunks [][]byte
+}
+
+func (c *sliceChunks) Next() ([]byte, error) {
+	if len(c.chunks) == 0 {
+		return nil, io.EOF
+	}
+	chunk := c.chunks[0]
+	c.chunks = c.chunks[1:]
+	return chunk, nil
+}
+
+func (c *sliceChunks) Close() error {
+	return nil
+}
+
+// readerChunks is a ChunkSource which reads a body in chunks of ChunkSize
+type readerChunks struct {
+	r    io.Reader
+	size int64
+	eof  bool
+}
+
+// newReaderChunks creates a ChunkSource of the body which starts with head and
+// continues with rest
+func newReaderChunks(head []byte, rest io.Reader) *readerChunks {
+	return &readerChunks{r: io.MultiReader(bytes.NewReader(head), rest)}
+}
+
+func (c *readerChunks) Next() ([]byte, error) {
+	if c.eof {
+		return nil, io.EOF
+	}
+	chunk := make([]byte, ChunkSize)
+	n, err := io.ReadFull(c.r, chunk)
+	c.size += int64(n)
+	switch err {
+	case nil:
+		return chunk, nil
+	case io.EOF, io.ErrUnexpectedEOF:
+		c.eof = true
+		if n == 0 {
+			return nil, io.EOF
+		}
+		return chunk[:n], nil
+	}
+	return nil, err
+}
+
+func (c *readerChunks) Size() int64 {
+	return c.size
+}
+
+// started reports whether any of the body has been read
+func (c *readerChunks) started() bool {
+	return c.size > 0 || c.eof
+}
+
+// storedChunks is a ChunkSource of the chunks of a stored paste, used to copy
+// it into another
+type storedChunks struct {
+	ChunkStream
+	size    int64
+	started bool
+}
+
+func (c *storedChunks) Next() ([]byte, error) {
+	c.started = true
+	return c.ChunkStream.Next()
+}
+
+func (c *storedChunks) Size() int64 {
+	return c.size
+}
+
+// mapChunks is a ChunkSource which transforms the chunks of another
+type mapChunks struct {
+	ChunkSource
+	fn func([]byte) ([]byte, error)
+}
+
+func (c *mapChunks) Next() ([]byte, error) {
+	chunk, err := c.ChunkSource.Next()
+	if err != nil {
+		return nil, err
+	}
+	return c.fn(chunk)
+}
+
+// mapStream is a ChunkStream which transforms the chunks of another
+type mapStream struct {
+	ChunkStream
+	fn func([]byte) ([]byte, error)
+}
+
+func (c *mapStream) Next() ([]byte, error) {
+	chunk, err := c.ChunkStream.Next()
+	if err != nil {
+		return nil, err
+	}
+	return c.fn(chunk)
+}
+
+// chunkReader is an io.Reader of the body of a stored paste
+type chunkReader struct {
+	stream ChunkStream
+	buf    []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		chunk, err := r.stream.Next()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = chunk
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// readChunks reads every chunk of a stream into a single body and closes it
+func readChunks(stream ChunkStream) ([]byte, error) {
+	defer stream.Close()
+
+	var buf bytes.Buffer
+	for {
+		chunk, err := stream.Next()
+		if err == io.EOF {
+			return buf.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(chunk)
+	}
+}
+
+// writeChunks writes every chunk of a stream to w and closes it
+func writeChunks(w io.Writer, stream ChunkStream) error {
+	defer stream.Close()
+
+	for {
+		chunk, err := stream.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/blockloop/icanhazpaste/client"
+)
+
+// DefaultServerURL is the server the command line client uses when none is
+// configured
+const DefaultServerURL = "https://icanhazpaste.com"
+
+// passwordEnv is the environment variable the password of a paste is read
+// from. It is not a flag as the arguments of a process are visible to every
+// user of the machine
+const passwordEnv = "ICANHAZPASTE_PASSWORD"
+
+// command is a subcommand of the command line client
+type command struct {
+	usage string
+	help  string
+	run   func(args []string) error
+}
+
+// commands are the subcommands which make the binary a client rather than the
+// server. They are set in init as they refer to themselves to print usage
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"put": {
+			usage: "put [flags] [file...]",
+			help:  "Paste each file, or stdin if there are none, and print their URLs. They are protected\nby the password in $ICANHAZPASTE_PASSWORD if it is set",
+			run:   runPut,
+		},
+		"get": {
+			usage: "get [flags] <url>",
+			help:  "Print the exact bytes of a paste, giving the password in $ICANHAZPASTE_PASSWORD if it\nis protected",
+			run:   runGet,
+		},
+		"rm": {
+			usage: "rm [flags] <url>",
+			help:  "Delete a paste with the token saved when it was pasted, or -token",
+			run:   runRm,
+		},
+		"tee": {
+			usage: "tee [flags]",
+			help:  "Copy stdin to stdout while pasting it, then print its URL to stderr. It is protected by\nthe password in $ICANHAZPASTE_PASSWORD if it is set",
+			run:   runTee,
+		},
+	}
+}
+
+// errUsage is returned by commands which were run with the wrong arguments
+var errUsage = errors.New("usage")
+
+// errStopped is returned by a stoppableReader once it is stopped
+var errStopped = errors.New("reader stopped")
+
+// runCommand runs a subcommand and returns the status the process exits with
+func runCommand(name string, args []string) int {
+	cmd := commands[name]
+	err := cmd.run(args)
+	switch err {
+	case nil:
+		return 0
+	case errUsage:
+		fmt.Fprintf(os.Stderr, "usage: icanhazpaste %s\n%s\n", cmd.usage, cmd.help)
+		return 2
+	case flag.ErrHelp:
+		return 2
+	}
+	fmt.Fprintf(os.Stderr, "icanhazpaste %s: %s\n", name, err)
+	return 1
+}
+
+// newFlagSet creates the flags of a subcommand which print its usage when they
+// fail to parse
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		cmd := commands[name]
+		fmt.Fprintf(os.Stderr, "usage: icanhazpaste %s\n%s\n", cmd.usage, cmd.help)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// pasteFlags are the flags of the subcommands which create pastes
+type pasteFlags struct {
+	server   *string
+	name     *string
+	filename *string
+	lang     *string
+	ttl      *string
+	burn     *bool
+	edit     *bool
+}
+
+func addPasteFlags(fs *flag.FlagSet) *pasteFlags {
+	return &pasteFlags{
+		server:   fs.String("server", "", "URL of the server (default from $ICANHAZPASTE_URL or the config file)"),
+		name:     fs.String("name", "", "Name of the paste"),
+		filename: fs.String("filename", "", "Filename of the paste, used to detect its type"),
+		lang:     fs.String("lang", "", "Language the paste is highlighted as"),
+		ttl:      fs.String("ttl", "", "How long the paste is kept, such as 10m or 7d"),
+		burn:     fs.Bool("burn", false, "Delete the paste after it has been read once"),
+		edit:     fs.Bool("edit", false, "Make the paste editable and print its edit token to stderr"),
+	}
+}
+
+// options returns the options for a new paste chosen by the flags
+func (f *pasteFlags) options() (*client.Options, error) {
+	opts := &client.Options{
+		Name:     *f.name,
+		Filename: *f.filename,
+		Language: *f.lang,
+		Burn:     *f.burn,
+		Editable: *f.edit,
+		Password: os.Getenv(passwordEnv),
+	}
+	if *f.ttl != "" {
+		ttl, err := parseTTL(*f.ttl)
+		if err != nil {
+			return nil, err
+		}
+		opts.TTL = ttl
+	}
+	return opts, nil
+}
+
+func runPut(args []string) error {
+	fs := newFlagSet("put")
+	pf := addPasteFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	opts, err := pf.options()
+	if err != nil {
+		return err
+	}
+	if *pf.name != "" && fs.NArg() > 1 {
+		return errors.New("-name can only be given for a single paste")
+	}
+	c, err := newClient(*pf.server)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		return create(c, os.Stdin, opts, os.Stdout)
+	}
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		fileOpts := *opts
+		if fileOpts.Filename == "" {
+			fileOpts.Filename = filepath.Base(path)
+		}
+		err = create(c, f, &fileOpts, os.Stdout)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	return nil
+}
+
+func runTee(args []string) error {
+	fs := newFlagSet("tee")
+	pf := addPasteFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return errUsage
+	}
+	opts, err := pf.options()
+	if err != nil {
+		return err
+	}
+	c, err := newClient(*pf.server)
+	if err != nil {
+		return err
+	}
+	in := &stoppableReader{r: io.TeeReader(os.Stdin, os.Stdout)}
+	err = create(c, in, opts, os.Stderr)
+	// a paste which failed leaves the rest of stdin unread, which is still
+	// passed through so the output is never cut short. The request may still
+	// be reading the paste, so it is stopped first
+	in.stop()
+	if _, cerr := io.Copy(os.Stdout, os.Stdin); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// stoppableReader reads from r until it is stopped, after which reads fail
+type stoppableReader struct {
+	mu      sync.Mutex
+	r       io.Reader
+	stopped bool
+}
+
+func (s *stoppableReader) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return 0, errStopped
+	}
+	return s.r.Read(p)
+}
+
+// stop waits for any read in progress to finish and fails every read after it
+func (s *stoppableReader) stop() {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+}
+
+// create pastes r, printing its URL to out and saving its delete token so it
+// can be removed later
+func create(c *client.Client, r io.Reader, opts *client.Options, out io.Writer) error {
+	p, err := c.Create(context.Background(), r, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, p.URL)
+	if p.EditToken != "" {
+		fmt.Fprintf(os.Stderr, "edit token: %s\n", p.EditToken)
+	}
+	if err := saveToken(c.BaseURL(), p.Name, p.DeleteToken); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save delete token %s: %s\n", p.DeleteToken, err)
+	}
+	return nil
+}
+
+func runGet(args []string) error {
+	fs := newFlagSet("get")
+	server := fs.String("server", "", "URL of the server, if a name is given rather than a URL")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	c, name, err := pasteClient(fs.Arg(0), *server)
+	if err != nil {
+		return err
+	}
+
+	body, err := c.GetWithPassword(context.Background(), name, os.Getenv(passwordEnv))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	_, err = io.Copy(os.Stdout, body)
+	return err
+}
+
+func runRm(args []string) error {
+	fs := newFlagSet("rm")
+	server := fs.String("server", "", "URL of the server, if a name is given rather than a URL")
+	token := fs.String("token", "", "Delete token of the paste (default the token saved by put)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	c, name, err := pasteClient(fs.Arg(0), *server)
+	if err != nil {
+		return err
+	}
+
+	if *token == "" {
+		*token, err = loadToken(c.BaseURL(), name)
+		if err != nil {
+			return err
+		}
+	}
+	if err := c.Delete(context.Background(), name, *token); err != nil {
+		return err
+	}
+	return forgetToken(c.BaseURL(), name)
+}
+
+// newClient creates a client for the server given by a flag, the
+// ICANHAZPASTE_URL environment variable or the config file, in that order
+func newClient(server string) (*client.Client, error) {
+	if server == "" {
+		server = os.Getenv("ICANHAZPASTE_URL")
+	}
+	if server == "" {
+		cfg, err := readConfig()
+		if err != nil {
+			return nil, err
+		}
+		server = cfg["url"]
+	}
+	if server == "" {
+		server = DefaultServerURL
+	}
+	return client.New(server)
+}
+
+// pasteClient returns a client for the server a paste is on and the paste's
+// name. Pastes may be given by their URL, in which case the server is taken
+// from it, or by their name alone
+func pasteClient(arg, server string) (*client.Client, string, error) {
+	if !strings.Contains(arg, "/") {
+		c, err := newClient(server)
+		return c, arg, err
+	}
+
+	u, err := url.Parse(arg)
+	if err != nil {
+		return nil, "", err
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	var name string
+	switch {
+	case len(parts) >= 2 && (parts[0] == "x" || parts[0] == "raw"):
+		name = parts[1]
+	case len(parts) >= 4 && parts[0] == "api" && parts[2] == "pastes":
+		name = parts[3]
+	default:
+		return nil, "", fmt.Errorf("%s is not the URL of a paste", arg)
+	}
+	// bundles are downloaded from /x/{name}.tar.gz and .zip
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".tar.gz"), ".zip")
+
+	c, err := client.New(u.Scheme + "://" + u.Host)
+	return c, name, err
+}
+
+// configDir returns the directory holding the client's config file and saved
+// tokens
+func configDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "icanhazpaste")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".config", "icanhazpaste")
+}
+
+// readConfig reads the config file, which holds key = value lines. Lines
+// starting with # are comments. A missing file is the same as an empty one
+func readConfig() (map[string]string, error) {
+	cfg := make(map[string]string)
+	f, err := os.Open(filepath.Join(configDir(), "config"))
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%s:%d: expected key = value", f.Name(), n)
+		}
+		cfg[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+	}
+	return cfg, scanner.Err()
+}
+
+// tokensPath returns the path of the file holding the delete tokens of pastes,
+// one server URL, paste name and token per line
+func tokensPath() string {
+	return filepath.Join(configDir(), "tokens")
+}
+
+// saveToken records the delete token of a paste on server so rm can find it
+func saveToken(server, name, token string) error {
+	if err := os.MkdirAll(configDir(), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(tokensPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s %s %s\n", server, name, token)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// loadToken finds the delete token saved for a paste on server. Pastes with
+// the same name on different servers are different pastes
+func loadToken(server, name string) (string, error) {
+	raw, err := ioutil.ReadFile(tokensPath())
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		if s, n, token, ok := parseSavedToken(line); ok && s == server && n == name {
+			return token, nil
+		}
+	}
+	return "", fmt.Errorf("no delete token saved for %s on %s, give one with -token", name, server)
+}
+
+// forgetToken removes the saved delete token of a paste which was deleted
+func forgetToken(server, name string) error {
+	raw, err := ioutil.ReadFile(tokensPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var kept []string
+	for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\n") {
+		if s, n, _, ok := parseSavedToken(line); ok && (s != server || n != name) {
+			kept = append(kept, line)
+		}
+	}
+	out := strings.Join(kept, "\n")
+	if out != "" {
+		out += "\n"
+	}
+	return ioutil.WriteFile(tokensPath(), []byte(out), 0600)
+}
+
+// parseSavedToken parses a line of the tokens file
+func parseSavedToken(line string) (server, name, token string, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return "", "", "", false
+	}
+	return fields[0], fields[1], fields[2], true
+}
@@ -0,0 +1,290 @@
+// Package client creates and reads pastes through the icanhazpaste API
+//
+//	c, err := client.New("https://icanhazpaste.com")
+//	if err != nil {
+//		return err
+//	}
+//	p, err := c.Create(ctx, strings.NewReader(diagnostics), &client.Options{TTL: time.Hour})
+//	if err != nil {
+//		return err
+//	}
+//	log.Printf("diagnostics at %s", p.URL)
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// apiPath is the path of the API on the server
+	apiPath = "/api/v1"
+	// maxErrorSize is the most read of the body of an error response
+	maxErrorSize = 64 * 1024
+	// userAgent identifies the client to the server
+	userAgent = "icanhazpaste-client"
+)
+
+// Client creates and reads pastes on an icanhazpaste server. It is safe for
+// concurrent use
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+}
+
+// Options are the settings chosen for a new paste. The zero value creates a
+// paste with the server's defaults
+type Options struct {
+	// Name is the name requested for the paste. A name is generated if it is
+	// empty
+	Name string
+	// Filename is the name of the file being pasted, which the server uses to
+	// decide its type and language
+	Filename string
+	// ContentType is the media type of the paste. It is detected by the
+	// server if it is empty
+	ContentType string
+	// Language is the language the paste is highlighted as
+	Language string
+	// TTL is how long the paste is kept. Zero uses the server's default
+	TTL time.Duration
+	// Burn deletes the paste the first time it is read
+	Burn bool
+	// Password must be given to read the paste if it is set
+	Password string
+	// Editable asks for an edit token so revisions can be added to the paste
+	Editable bool
+	// OwnerToken is the owner token of earlier pastes to list the paste with.
+	// The server makes a new token if it is empty
+	OwnerToken string
+}
+
+// Paste describes a paste stored on the server
+type Paste struct {
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	Created     time.Time `json:"created"`
+	Expires     time.Time `json:"expires"`
+	Burn        bool      `json:"burn"`
+	ContentType string    `json:"content_type"`
+	Filename    string    `json:"filename"`
+	Language    string    `json:"language"`
+	Format      string    `json:"format"`
+	Protected   bool      `json:"protected"`
+	Editable    bool      `json:"editable"`
+	Parent      string    `json:"parent"`
+	Revision    int       `json:"revision"`
+	// URL is where the paste is shown and RawURL where its exact bytes are
+	// served
+	URL    string `json:"url"`
+	RawURL string `json:"raw_url"`
+}
+
+// CreatedPaste is a newly created paste along with the tokens which manage it.
+// The server only gives them out once
+type CreatedPaste struct {
+	Paste
+	// DeleteToken must be given to delete the paste before it expires
+	DeleteToken string `json:"delete_token"`
+	// EditToken must be given to add revisions to the paste. It is only set
+	// for pastes created with Editable
+	EditToken string `json:"edit_token"`
+	// OwnerToken lists the paste along with the others created with it
+	OwnerToken string `json:"owner_token"`
+}
+
+// New creates a Client for the server at baseURL, such as
+// https://icanhazpaste.com
+func New(baseURL string) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("server url must be http or https")
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return &Client{
+		baseURL:    u,
+		httpClient: &http.Client{},
+	}, nil
+}
+
+// BaseURL returns the URL of the server the client makes requests to
+func (c *Client) BaseURL() string {
+	return c.baseURL.String()
+}
+
+// SetHTTPClient sets the client used to make requests to the server
+func (c *Client) SetHTTPClient(hc *http.Client) {
+	c.httpClient = hc
+}
+
+// Create stores the contents of r as a new paste. r is streamed to the server
+// so it may be larger than fits in memory, up to the size the server accepts
+func (c *Client) Create(ctx context.Context, r io.Reader, opts *Options) (*CreatedPaste, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	query := url.Values{}
+	for k, v := range map[string]string{
+		"name":     opts.Name,
+		"filename": opts.Filename,
+		"lang":     opts.Language,
+	} {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	if opts.TTL > 0 {
+		// the server reads a bare number as seconds
+		query.Set("ttl", strconv.FormatInt(int64((opts.TTL+time.Second-1)/time.Second), 10))
+	}
+	if opts.Burn {
+		query.Set("burn", "1")
+	}
+	if opts.Editable {
+		query.Set("edit", "1")
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/pastes", query, r)
+	if err != nil {
+		return nil, err
+	}
+	contentType := opts.ContentType
+	if contentType == "" || contentType == "application/json" {
+		// json bodies are read by the server as a request rather than a
+		// paste, so the server is left to detect the type instead
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+	if opts.Password != "" {
+		req.Header.Set("X-Paste-Password", opts.Password)
+	}
+	if opts.OwnerToken != "" {
+		req.Header.Set("X-Owner-Token", opts.OwnerToken)
+	}
+
+	var p CreatedPaste
+	if err := c.doJSON(req, http.StatusCreated, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Get opens the exact bytes of a paste. Pastes created with Burn are deleted
+// once they are opened. The caller must close the returned reader
+func (c *Client) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return c.GetWithPassword(ctx, name, "")
+}
+
+// GetWithPassword opens a paste like Get, giving the password of a paste which
+// is protected by one
+func (c *Client) GetWithPassword(ctx context.Context, name, password string) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/pastes/"+url.PathEscape(name)+"/raw", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if password != "" {
+		req.Header.Set("X-Paste-Password", password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp.Body, nil
+}
+
+// Stat returns information about a paste without reading it, so pastes
+// created with Burn are not deleted
+func (c *Client) Stat(ctx context.Context, name string) (*Paste, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/pastes/"+url.PathEscape(name)+"/info", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var p Paste
+	if err := c.doJSON(req, http.StatusOK, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// List returns the pastes which have not expired created with an owner token,
+// newest first
+func (c *Client) List(ctx context.Context, ownerToken string) ([]*Paste, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/pastes", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Owner-Token", ownerToken)
+	var list struct {
+		Pastes []*Paste `json:"pastes"`
+	}
+	if err := c.doJSON(req, http.StatusOK, &list); err != nil {
+		return nil, err
+	}
+	return list.Pastes, nil
+}
+
+// Delete removes a paste before it expires using the delete token given out
+// when it was created
+func (c *Client) Delete(ctx context.Context, name, deleteToken string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, "/pastes/"+url.PathEscape(name), nil, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Delete-Token", deleteToken)
+	return c.doJSON(req, http.StatusNoContent, nil)
+}
+
+// newRequest creates a request to path under the API. Names in path must
+// already be escaped
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	u := *c.baseURL
+	u.RawPath = u.EscapedPath() + apiPath + path
+	var err error
+	if u.Path, err = url.PathUnescape(u.RawPath); err != nil {
+		return nil, err
+	}
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+	return req.WithContext(ctx), nil
+}
+
+// doJSON makes a request which succeeds with status and decodes the response
+// into v, unless v is nil
+func (c *Client) doJSON(req *http.Request, status int, v interface{}) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != status {
+		return responseError(resp)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,222 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client of a server which answers every request with
+// handler. The server must be closed
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*Client, *httptest.Server) {
+	srv := httptest.NewServer(handler)
+	c, err := New(srv.URL)
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return c, srv
+}
+
+func TestResponseError(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"not found", 404, `{"error": {"status": 404, "code": "not_found", "message": "paste not found"}}`, ErrNotFound},
+		{"unauthorized", 401, `{"error": {"status": 401, "code": "unauthorized", "message": "password required"}}`, ErrUnauthorized},
+		{"forbidden", 403, "", ErrForbidden},
+		{"exists", 409, `{"error": {"status": 409, "code": "exists", "message": "paste already exists"}}`, ErrExists},
+		{"too large without a body", 413, "", ErrTooLarge},
+		{"too large from a proxy", 413, "<html>413 Request Entity Too Large</html>", ErrTooLarge},
+		{"rate limited", 429, `{"error": {"status": 429, "code": "rate_limited", "message": "slow down"}}`, ErrRateLimited},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, srv := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			defer srv.Close()
+			_, err := c.Stat(context.Background(), "paste")
+			if err != tt.want {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseErrorBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want Error
+	}{
+		{
+			name: "api error",
+			body: `{"error": {"status": 400, "code": "bad_request", "message": "unknown language \"x\""}}`,
+			want: Error{StatusCode: 400, Code: "bad_request", Message: `unknown language "x"`},
+		},
+		{
+			name: "not json",
+			body: "Bad Request",
+			want: Error{StatusCode: 400, Code: "unknown", Message: "Bad Request"},
+		},
+		{
+			name: "no code",
+			body: `{"error": {"message": "what"}}`,
+			want: Error{StatusCode: 400, Code: "unknown", Message: "Bad Request"},
+		},
+		{
+			name: "larger than an error",
+			body: `{"error": {"status": 400, "code": "bad_request", "message": "` + strings.Repeat("x", maxErrorSize) + `"}}`,
+			want: Error{StatusCode: 400, Code: "unknown", Message: "Bad Request"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, srv := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(tt.body))
+			})
+			defer srv.Close()
+			_, err := c.Stat(context.Background(), "paste")
+			got, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("got %v, want an *Error", err)
+			}
+			if *got != tt.want {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreate(t *testing.T) {
+	c, srv := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/pastes" {
+			t.Errorf("got %s %s, want POST /api/v1/pastes", r.Method, r.URL.Path)
+		}
+		q := r.URL.Query()
+		for k, want := range map[string]string{"name": "notes", "ttl": "3600", "burn": "1"} {
+			if got := q.Get(k); got != want {
+				t.Errorf("query %s is %q, want %q", k, got, want)
+			}
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/octet-stream" {
+			t.Errorf("content type is %q, want application/octet-stream", got)
+		}
+		if got := r.Header.Get("X-Paste-Password"); got != "secret" {
+			t.Errorf("password is %q, want secret", got)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != `{"a": 1}` {
+			t.Errorf("body is %q", body)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"name": "notes", "size": 8, "url": "http://paste/x/notes", "delete_token": "d", "owner_token": "o"}`))
+	})
+	defer srv.Close()
+	p, err := c.Create(context.Background(), strings.NewReader(`{"a": 1}`), &Options{
+		Name:        "notes",
+		ContentType: "application/json",
+		TTL:         time.Hour,
+		Burn:        true,
+		Password:    "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "notes" || p.Size != 8 || p.URL != "http://paste/x/notes" || p.DeleteToken != "d" || p.OwnerToken != "o" {
+		t.Errorf("got %+v", p)
+	}
+}
+
+func TestGet(t *testing.T) {
+	c, srv := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/pastes/notes/raw" {
+			t.Errorf("got path %s", r.URL.Path)
+		}
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+	rc, err := c.Get(context.Background(), "notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	body, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "hello" {
+		t.Errorf("got %q, want hello", body)
+	}
+}
+
+func TestList(t *testing.T) {
+	c, srv := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Owner-Token"); got != "owner" {
+			t.Errorf("owner token is %q, want owner", got)
+		}
+		w.Write([]byte(`{"pastes": [{"name": "b"}, {"name": "a"}]}`))
+	})
+	defer srv.Close()
+	pastes, err := c.List(context.Background(), "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pastes) != 2 || pastes[0].Name != "b" || pastes[1].Name != "a" {
+		t.Errorf("got %+v", pastes)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	c, srv := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete || r.URL.Path != "/api/v1/pastes/notes" {
+			t.Errorf("got %s %s, want DELETE /api/v1/pastes/notes", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("X-Delete-Token"); got != "token" {
+			t.Errorf("delete token is %q, want token", got)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	defer srv.Close()
+	if err := c.Delete(context.Background(), "notes", "token"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestEscapedNames checks names are escaped so they cannot reach other routes
+// of the API
+func TestEscapedNames(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.URL.EscapedPath())
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	c, err := New(srv.URL + "/base%20path/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	c.Get(ctx, "../../x")
+	c.Stat(ctx, "a/b?c#d")
+	c.Delete(ctx, "a b", "token")
+	want := []string{
+		"/base%20path/api/v1/pastes/..%2F..%2Fx/raw",
+		"/base%20path/api/v1/pastes/a%2Fb%3Fc%23d/info",
+		"/base%20path/api/v1/pastes/a%20b",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
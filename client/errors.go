@@ -0,0 +1,92 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Errors returned for the failures callers are most likely to handle. Other
+// failures reported by the server are returned as an *Error
+var (
+	// ErrNotFound is returned when a paste does not exist or has expired
+	ErrNotFound = errors.New("paste not found")
+	// ErrUnauthorized is returned when a paste is protected by a password and
+	// the password was missing or wrong
+	ErrUnauthorized = errors.New("paste is protected by a password")
+	// ErrForbidden is returned when a delete token is wrong
+	ErrForbidden = errors.New("invalid delete token")
+	// ErrExists is returned when the name chosen for a paste is taken
+	ErrExists = errors.New("paste already exists")
+	// ErrTooLarge is returned when a paste is larger than the server accepts
+	ErrTooLarge = errors.New("paste is too large")
+	// ErrRateLimited is returned when too many pastes have been created
+	ErrRateLimited = errors.New("too many pastes created")
+)
+
+// statusErrors are the errors returned for the statuses of error responses.
+// They are mapped by status rather than by the code in the body as not every
+// response has one, such as when the server refuses a request which is too
+// large before reading it
+var statusErrors = map[int]error{
+	http.StatusNotFound:              ErrNotFound,
+	http.StatusUnauthorized:          ErrUnauthorized,
+	http.StatusForbidden:             ErrForbidden,
+	http.StatusConflict:              ErrExists,
+	http.StatusRequestEntityTooLarge: ErrTooLarge,
+	http.StatusTooManyRequests:       ErrRateLimited,
+}
+
+// Error is an error response from the server
+type Error struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int
+	// Code identifies the kind of error, such as "bad_request"
+	Code string
+	// Message describes the error
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("icanhazpaste: %s (%d %s)", e.Message, e.StatusCode, e.Code)
+}
+
+// errorResponse is the body of error responses from the API
+type errorResponse struct {
+	Error struct {
+		Status  int    `json:"status"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// responseError returns the error described by an unsuccessful response.
+// Statuses callers are likely to handle are mapped to their errors first, then
+// anything else is described by the body. Responses which are not from the
+// API, such as from a proxy in front of it, are described by their status
+func responseError(resp *http.Response) error {
+	// whatever is left of the body is read so the connection can be reused,
+	// but no more of it than of an error worth decoding
+	defer io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxErrorSize))
+
+	if err, ok := statusErrors[resp.StatusCode]; ok {
+		return err
+	}
+	var body errorResponse
+	err := json.NewDecoder(io.LimitReader(resp.Body, maxErrorSize)).Decode(&body)
+	if err != nil || body.Error.Code == "" {
+		return &Error{
+			StatusCode: resp.StatusCode,
+			Code:       "unknown",
+			Message:    http.StatusText(resp.StatusCode),
+		}
+	}
+	return &Error{
+		StatusCode: resp.StatusCode,
+		Code:       body.Error.Code,
+		Message:    body.Error.Message,
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/blockloop/icanhazpaste/diff"
+	"github.com/go-chi/chi"
+	"github.com/pressly/chi/render"
+)
+
+// maxDiffSize is the size of the largest paste which can be compared
+const maxDiffSize = 1 * Megabyte
+
+var (
+	// ErrDiffTooLarge is an error indicating a paste is too large to compare
+	ErrDiffTooLarge = fmt.Errorf("pastes larger than %d bytes cannot be compared", maxDiffSize)
+	// ErrDiffEncrypted is an error indicating a paste was encrypted in the
+	// browser so its text cannot be compared
+	ErrDiffEncrypted = fmt.Errorf("pastes encrypted in the browser cannot be compared")
+	// ErrBurnDiff is an error indicating a paste which burns was compared,
+	// which would burn it
+	ErrBurnDiff = fmt.Errorf("pastes which burn cannot be compared")
+)
+
+// getDiff compares two pastes, or two revisions of pastes named as name@rev,
+// as a unified diff for curl and a side by side HTML page for browsers
+func (h *Handler) getDiff(w http.ResponseWriter, r *http.Request) {
+	html := render.GetAcceptedContentType(r) == render.ContentTypeHTML
+
+	// both pastes are checked before either is read, so a request which
+	// fails never reads the other
+	a, ok := h.diffPaste(w, r, chi.URLParam(r, "a"), html)
+	if !ok {
+		return
+	}
+	b, ok := h.diffPaste(w, r, chi.URLParam(r, "b"), html)
+	if !ok {
+		return
+	}
+	if !h.readDiffPaste(w, a) || !h.readDiffPaste(w, b) {
+		return
+	}
+
+	var lines []diff.Line
+	if a.text() && b.text() {
+		lines = diff.Lines(string(a.Body), string(b.Body))
+	}
+	if html {
+		renderDiffHTML(w, r, a, b, lines)
+		return
+	}
+	if lines == nil {
+		if string(a.Body) != string(b.Body) {
+			render.PlainText(w, r, fmt.Sprintf("Binary pastes %s and %s differ\n", a.Spec, b.Spec))
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	fmt.Fprint(w, diff.Unified(a.Spec, b.Spec, diff.Hunks(lines, diff.DefaultContext)))
+}
+
+// comparedPaste is one of the pastes being compared
+type comparedPaste struct {
+	// Spec is how the paste was named in the URL
+	Spec string
+	Body []byte
+	Info *PasteInfo
+	// Rev is the revision compared, or 0 for the latest
+	Rev int
+}
+
+// Path returns the path the paste is served from
+func (p *comparedPaste) Path() string {
+	if p.Rev == 0 {
+		return "/x/" + p.Info.Name
+	}
+	return "/x/" + p.Info.Name + "/v/" + strconv.Itoa(p.Rev)
+}
+
+// text reports whether the paste holds text which can be compared line by
+// line. Pastes stored before their type was recorded are assumed to be text
+func (p *comparedPaste) text() bool {
+	return p.Info.ContentType == "" || isText(p.Info.ContentType)
+}
+
+// diffPaste checks one of the pastes being compared without reading it,
+// responding with an error if it cannot be compared
+func (h *Handler) diffPaste(w http.ResponseWriter, r *http.Request, spec string, html bool) (*comparedPaste, bool) {
+	name, rev, err := parseDiffName(spec)
+	if err != nil {
+		sendError(w, 404, ErrNotFound)
+		return nil, false
+	}
+	info, ok := h.authorize(w, r, name, html)
+	if !ok {
+		return nil, false
+	}
+	if info.Burn {
+		sendError(w, http.StatusBadRequest, ErrBurnDiff)
+		return nil, false
+	}
+	if info.Format == FormatEncrypted {
+		sendError(w, http.StatusBadRequest, ErrDiffEncrypted)
+		return nil, false
+	}
+	if rev > info.Revision {
+		sendError(w, 404, ErrNotFound)
+		return nil, false
+	}
+	if rev == 0 && info.Size > maxDiffSize {
+		sendError(w, http.StatusRequestEntityTooLarge, ErrDiffTooLarge)
+		return nil, false
+	}
+	return &comparedPaste{Spec: spec, Info: info, Rev: rev}, true
+}
+
+// readDiffPaste reads the body of a paste checked by diffPaste, responding
+// with an error if it cannot be read
+func (h *Handler) readDiffPaste(w http.ResponseWriter, p *comparedPaste) bool {
+	var (
+		body []byte
+		info *PasteInfo
+		err  error
+	)
+	if p.Rev == 0 {
+		body, info, err = h.store.Get(p.Info.Name)
+	} else {
+		var stream ChunkStream
+		stream, info, err = h.store.GetRevision(p.Info.Name, p.Rev)
+		if err == nil && info.Size > maxDiffSize {
+			stream.Close()
+			sendError(w, http.StatusRequestEntityTooLarge, ErrDiffTooLarge)
+			return false
+		}
+		if err == nil {
+			body, err = readChunks(stream)
+		}
+	}
+	if err == ErrNotFound {
+		sendError(w, 404, ErrNotFound)
+		return false
+	}
+	if err != nil {
+		sendError(w, 500, err)
+		return false
+	}
+	p.Body, p.Info = body, info
+	return true
+}
+
+// parseDiffName splits a paste named for comparison into its name and
+// revision. Names without a revision compare the latest, which is revision 0
+func parseDiffName(spec string) (string, int, error) {
+	i := strings.LastIndex(spec, "@")
+	if i < 0 {
+		return spec, 0, nil
+	}
+	rev, err := strconv.Atoi(spec[i+1:])
+	if err != nil || rev < 1 {
+		return "", 0, fmt.Errorf("invalid revision %q", spec[i+1:])
+	}
+	return spec[:i], rev, nil
+}
@@ -0,0 +1,230 @@
+// Package diff compares texts line by line and formats the differences
+package diff
+
+import (
+	"strings"
+)
+
+// Op is what happened to a line between the old and new text
+type Op int
+
+// Ops of lines in a diff
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// Line is a line of either text in a diff
+type Line struct {
+	Op Op
+	// Text is the line including its newline, if it has one
+	Text string
+	// A and B are the numbers of the line in the old and new text counting
+	// from 1, or 0 if it is not in that text
+	A, B int
+}
+
+// maxCost is the number of edits searched for before a part of the texts is
+// given up on as completely different, which bounds the time taken to compare
+// texts with few lines in common
+const maxCost = 1024
+
+// Lines compares two texts and returns every line of both in order, with the
+// lines only in a marked Delete and those only in b marked Insert. The fewest
+// lines possible are marked unless the texts are too different to find them
+// quickly
+func Lines(a, b string) []Line {
+	d := newDiffer(SplitLines(a), SplitLines(b))
+	d.compare(0, len(d.a), 0, len(d.b))
+	return d.lines()
+}
+
+// SplitLines splits text into lines which keep their newlines
+func SplitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// differ finds the shortest edit script between two sequences of lines with
+// the linear space variant of Myers' algorithm
+type differ struct {
+	aLines, bLines []string
+	// a and b hold the lines as ids so they are cheap to compare
+	a, b []int
+	// deleted and inserted mark the lines which are only in one of the texts
+	deleted, inserted []bool
+}
+
+func newDiffer(a, b []string) *differ {
+	ids := make(map[string]int)
+	intern := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, l := range lines {
+			id, ok := ids[l]
+			if !ok {
+				id = len(ids)
+				ids[l] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+	return &differ{
+		aLines:   a,
+		bLines:   b,
+		a:        intern(a),
+		b:        intern(b),
+		deleted:  make([]bool, len(a)),
+		inserted: make([]bool, len(b)),
+	}
+}
+
+// compare marks the lines which differ between a[aLo:aHi] and b[bLo:bHi]
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+
+	switch {
+	case aLo == aHi:
+		for i := bLo; i < bHi; i++ {
+			d.inserted[i] = true
+		}
+	case bLo == bHi:
+		for i := aLo; i < aHi; i++ {
+			d.deleted[i] = true
+		}
+	default:
+		x, y, ok := d.bisect(aLo, aHi, bLo, bHi)
+		if !ok {
+			for i := aLo; i < aHi; i++ {
+				d.deleted[i] = true
+			}
+			for i := bLo; i < bHi; i++ {
+				d.inserted[i] = true
+			}
+			return
+		}
+		d.compare(aLo, x, bLo, y)
+		d.compare(x, aHi, y, bHi)
+	}
+}
+
+// bisect finds a point on the shortest path through the edit graph of
+// a[aLo:aHi] and b[bLo:bHi] where the paths searched forwards from the start
+// and backwards from the end meet, so each half can be compared separately
+func (d *differ) bisect(aLo, aHi, bLo, bHi int) (x, y int, ok bool) {
+	n, m := aHi-aLo, bHi-bLo
+	maxD := (n + m + 1) / 2
+	if maxD > maxCost {
+		maxD = maxCost
+	}
+	offset := maxD + 1
+	// vf and vb hold the furthest x reached on each diagonal searching
+	// forwards and backwards. -1 marks diagonals not reached yet
+	vf := make([]int, 2*offset+1)
+	vb := make([]int, 2*offset+1)
+	for i := range vf {
+		vf[i], vb[i] = -1, -1
+	}
+	vf[offset+1], vb[offset+1] = 0, 0
+
+	delta := n - m
+	// paths meet going forwards when the difference in lengths is odd
+	front := delta%2 != 0
+	var kfStart, kfEnd, kbStart, kbEnd int
+
+	for step := 0; step < maxD; step++ {
+		for k := -step + kfStart; k <= step-kfEnd; k += 2 {
+			i := offset + k
+			var x int
+			if k == -step || (k != step && vf[i-1] < vf[i+1]) {
+				x = vf[i+1]
+			} else {
+				x = vf[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			vf[i] = x
+			switch {
+			case x > n:
+				// ran off the right of the graph
+				kfEnd += 2
+			case y > m:
+				// ran off the bottom of the graph
+				kfStart += 2
+			case front:
+				j := offset + delta - k
+				if j >= 0 && j < len(vb) && vb[j] != -1 && x >= n-vb[j] {
+					return aLo + x, bLo + y, true
+				}
+			}
+		}
+
+		for k := -step + kbStart; k <= step-kbEnd; k += 2 {
+			i := offset + k
+			var x int
+			if k == -step || (k != step && vb[i-1] < vb[i+1]) {
+				x = vb[i+1]
+			} else {
+				x = vb[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.a[aHi-x-1] == d.b[bHi-y-1] {
+				x++
+				y++
+			}
+			vb[i] = x
+			switch {
+			case x > n:
+				kbEnd += 2
+			case y > m:
+				kbStart += 2
+			case !front:
+				j := offset + delta - k
+				if j >= 0 && j < len(vf) && vf[j] != -1 {
+					fx := vf[j]
+					fy := fx - (j - offset)
+					if fx >= n-x {
+						return aLo + fx, bLo + fy, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// lines lists the lines of both texts in order once they have been compared.
+// Deleted lines come before the lines inserted in their place
+func (d *differ) lines() []Line {
+	var out []Line
+	i, j := 0, 0
+	for i < len(d.a) || j < len(d.b) {
+		switch {
+		case i < len(d.a) && d.deleted[i]:
+			out = append(out, Line{Op: Delete, Text: d.aLines[i], A: i + 1})
+			i++
+		case j < len(d.b) && d.inserted[j]:
+			out = append(out, Line{Op: Insert, Text: d.bLines[j], B: j + 1})
+			j++
+		default:
+			out = append(out, Line{Op: Equal, Text: d.aLines[i], A: i + 1, B: j + 1})
+			i++
+			j++
+		}
+	}
+	return out
+}
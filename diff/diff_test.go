@@ -0,0 +1,122 @@
+package diff
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// ops formats lines as one string per line, the op followed by the text
+// without its newline
+func ops(lines []Line) []string {
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		out[i] = string(" -+"[l.Op]) + strings.TrimSuffix(l.Text, "\n")
+	}
+	return out
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []string
+	}{
+		{"empty", "", "", nil},
+		{"identical", "a\nb\n", "a\nb\n", []string{" a", " b"}},
+		{"insert into empty", "", "a\nb\n", []string{"+a", "+b"}},
+		{"delete everything", "a\nb\n", "", []string{"-a", "-b"}},
+		{"change middle", "a\nb\nc\n", "a\nx\nc\n", []string{" a", "-b", "+x", " c"}},
+		{"insert at start", "b\nc\n", "a\nb\nc\n", []string{"+a", " b", " c"}},
+		{"delete at end", "a\nb\nc\n", "a\nb\n", []string{" a", " b", "-c"}},
+		{"missing newline", "a\nb", "a\nb\n", []string{" a", "-b", "+b"}},
+		{"lone carriage return", "a\n", "a\n\r", []string{" a", "+\r"}},
+		{"move", "a\nb\nc\nd\n", "c\nd\na\nb\n", []string{"-a", "-b", " c", " d", "+a", "+b"}},
+		{"repeated lines", "a\na\nb\na\n", "a\nb\na\na\n", []string{" a", "-a", " b", "+a", " a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ops(Lines(tt.a, tt.b))
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("Lines(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinesNumbers(t *testing.T) {
+	lines := Lines("a\nb\nc\n", "x\nb\nc\ny\n")
+	want := []Line{
+		{Op: Delete, Text: "a\n", A: 1},
+		{Op: Insert, Text: "x\n", B: 1},
+		{Op: Equal, Text: "b\n", A: 2, B: 2},
+		{Op: Equal, Text: "c\n", A: 3, B: 3},
+		{Op: Insert, Text: "y\n", B: 4},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(lines), len(want))
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d = %+v, want %+v", i, lines[i], want[i])
+		}
+	}
+}
+
+// lcs returns the length of the longest common subsequence of a and b
+func lcs(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				cur[j+1] = prev[j] + 1
+			case prev[j+1] > cur[j]:
+				cur[j+1] = prev[j+1]
+			default:
+				cur[j+1] = cur[j]
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// TestLinesShortest checks random texts are rebuilt from the diff and that it
+// marks as few lines as possible
+func TestLinesShortest(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	text := func() string {
+		var lines []string
+		for i := r.Intn(30); i > 0; i-- {
+			lines = append(lines, string(rune('a'+r.Intn(4))))
+		}
+		return strings.Join(lines, "\n")
+	}
+	for i := 0; i < 2000; i++ {
+		a, b := text(), text()
+		lines := Lines(a, b)
+
+		var gotA, gotB string
+		changed := 0
+		for _, l := range lines {
+			if l.Op != Insert {
+				gotA += l.Text
+			}
+			if l.Op != Delete {
+				gotB += l.Text
+			}
+			if l.Op != Equal {
+				changed++
+			}
+		}
+		if gotA != a || gotB != b {
+			t.Fatalf("Lines(%q, %q) rebuilds %q and %q", a, b, gotA, gotB)
+		}
+		aLines, bLines := SplitLines(a), SplitLines(b)
+		if want := len(aLines) + len(bLines) - 2*lcs(aLines, bLines); changed != want {
+			t.Fatalf("Lines(%q, %q) changes %d lines, want %d", a, b, changed, want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// DefaultContext is the number of unchanged lines shown around each change
+const DefaultContext = 3
+
+// Hunk is a run of changed lines along with the unchanged lines around them
+type Hunk struct {
+	// A and B are the numbers of the first line of the hunk in the old and
+	// new text, and ALen and BLen how many lines of each it covers
+	A, ALen int
+	B, BLen int
+	Lines   []Line
+}
+
+// Hunks groups the changed lines of a diff into hunks with up to context
+// unchanged lines before and after each change. Changes separated by no more
+// than twice context unchanged lines share a hunk
+func Hunks(lines []Line, context int) []Hunk {
+	var (
+		hunks []Hunk
+		start = -1
+		end   int
+	)
+	flush := func() {
+		if start < 0 {
+			return
+		}
+		h := Hunk{Lines: lines[start:end]}
+		for _, l := range h.Lines {
+			if l.A > 0 {
+				if h.A == 0 {
+					h.A = l.A
+				}
+				h.ALen++
+			}
+			if l.B > 0 {
+				if h.B == 0 {
+					h.B = l.B
+				}
+				h.BLen++
+			}
+		}
+		// hunks with no lines of a text are said to start after the line
+		// before them, as patch expects
+		if h.ALen == 0 {
+			h.A = countBefore(lines[:start], func(l Line) int { return l.A })
+		}
+		if h.BLen == 0 {
+			h.B = countBefore(lines[:start], func(l Line) int { return l.B })
+		}
+		hunks = append(hunks, h)
+		start = -1
+	}
+
+	for i, l := range lines {
+		if l.Op == Equal {
+			continue
+		}
+		from := i - context
+		if from < 0 {
+			from = 0
+		}
+		if start >= 0 && from > end {
+			flush()
+		}
+		if start < 0 {
+			start = from
+		}
+		end = i + context + 1
+		if end > len(lines) {
+			end = len(lines)
+		}
+	}
+	flush()
+	return hunks
+}
+
+// countBefore returns the last line number given by num in lines, or 0
+func countBefore(lines []Line, num func(Line) int) int {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if n := num(lines[i]); n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// Unified formats hunks in the unified format read by patch, naming the old
+// and new text a and b. Texts with no differences have no hunks and format as
+// an empty string
+func Unified(a, b string, hunks []Hunk) string {
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", a, b)
+	for _, h := range hunks {
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(h.A, h.ALen), hunkRange(h.B, h.BLen))
+		for _, l := range h.Lines {
+			switch l.Op {
+			case Equal:
+				buf.WriteByte(' ')
+			case Delete:
+				buf.WriteByte('-')
+			case Insert:
+				buf.WriteByte('+')
+			}
+			buf.WriteString(l.Text)
+			if !strings.HasSuffix(l.Text, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	return buf.String()
+}
+
+// hunkRange formats the start and length of a hunk, leaving out lengths of 1
+// like diff does
+func hunkRange(start, length int) string {
+	if length == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, length)
+}
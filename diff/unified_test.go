@@ -0,0 +1,100 @@
+package diff
+
+import (
+	"testing"
+)
+
+func TestHunks(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+	b := "1\nx\n3\n4\n5\n6\n7\n8\ny\n10\n"
+	tests := []struct {
+		context int
+		want    []Hunk
+	}{
+		{0, []Hunk{{A: 2, ALen: 1, B: 2, BLen: 1}, {A: 9, ALen: 1, B: 9, BLen: 1}}},
+		{1, []Hunk{{A: 1, ALen: 3, B: 1, BLen: 3}, {A: 8, ALen: 3, B: 8, BLen: 3}}},
+		{3, []Hunk{{A: 1, ALen: 10, B: 1, BLen: 10}}},
+	}
+	for _, tt := range tests {
+		hunks := Hunks(Lines(a, b), tt.context)
+		if len(hunks) != len(tt.want) {
+			t.Errorf("context %d: got %d hunks, want %d", tt.context, len(hunks), len(tt.want))
+			continue
+		}
+		for i, h := range hunks {
+			w := tt.want[i]
+			if h.A != w.A || h.ALen != w.ALen || h.B != w.B || h.BLen != w.BLen {
+				t.Errorf("context %d: hunk %d is -%d,%d +%d,%d, want -%d,%d +%d,%d", tt.context, i,
+					h.A, h.ALen, h.B, h.BLen, w.A, w.ALen, w.B, w.BLen)
+			}
+		}
+	}
+}
+
+// the wanted output of each test is what GNU diff -u prints for the same texts
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		context int
+		want    string
+	}{
+		{
+			name: "identical",
+			a:    "a\nb\n", b: "a\nb\n",
+			context: 3,
+			want:    "",
+		},
+		{
+			name: "change",
+			a:    "a\nb\nc\n", b: "a\nx\nc\n",
+			context: 3,
+			want: "--- a\n+++ b\n" +
+				"@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n",
+		},
+		{
+			name: "into empty",
+			a:    "", b: "a\n",
+			context: 3,
+			want: "--- a\n+++ b\n" +
+				"@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			name: "to empty",
+			a:    "a\nb\n", b: "",
+			context: 3,
+			want: "--- a\n+++ b\n" +
+				"@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			name: "insert after context",
+			a:    "1\n2\n3\n4\n5\n", b: "1\n2\n3\n4\n5\n6\n",
+			context: 1,
+			want: "--- a\n+++ b\n" +
+				"@@ -5 +5,2 @@\n 5\n+6\n",
+		},
+		{
+			name: "no newline at end",
+			a:    "a\nb", b: "a\nc",
+			context: 3,
+			want: "--- a\n+++ b\n" +
+				"@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+		{
+			name: "two hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", b: "1\nx\n3\n4\n5\n6\n7\n8\ny\n10\n",
+			context: 1,
+			want: "--- a\n+++ b\n" +
+				"@@ -1,3 +1,3 @@\n 1\n-2\n+x\n 3\n" +
+				"@@ -8,3 +8,3 @@\n 8\n-9\n+y\n 10\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Unified("a", "b", Hunks(Lines(tt.a, tt.b), tt.context))
+			if got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package highlight
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// detectLimit is the number of bytes examined when detecting a language
+const detectLimit = 16 << 10
+
+// shebangs maps interpreters named on a #! line to languages
+var shebangs = map[string]string{
+	"sh":      "shell",
+	"bash":    "shell",
+	"zsh":     "shell",
+	"python":  "python",
+	"python3": "python",
+	"node":    "javascript",
+	"ruby":    "ruby",
+}
+
+// hints are patterns which suggest a language, tried in order
+var hints = []struct {
+	lang    string
+	pattern *regexp.Regexp
+}{
+	{"diff", regexp.MustCompile(`(?m)^(diff --git |--- \S+.*\n\+\+\+ |@@ -\d+(,\d+)? \+\d+(,\d+)? @@)`)},
+	{"go", regexp.MustCompile(`(?m)^package \w+\s*$[\s\S]*^(func|import|type) `)},
+	{"html", regexp.MustCompile(`(?i)^\s*(<!doctype html|<html|<\?xml)`)},
+	{"dockerfile", regexp.MustCompile(`(?m)^FROM \S+[\s\S]*^(RUN|CMD|ENTRYPOINT|COPY) `)},
+	{"python", regexp.MustCompile(`(?m)^(def \w+\(.*\):|class \w+(\(.*\))?:|from [\w.]+ import |if __name__ == )`)},
+	{"c", regexp.MustCompile(`(?m)^#include\s*[<"]`)},
+	{"rust", regexp.MustCompile(`(?m)^\s*(pub )?fn \w+.*->|^use \w+::`)},
+	{"java", regexp.MustCompile(`(?m)^\s*(public|private) (static )?(class|void|final) `)},
+	{"javascript", regexp.MustCompile(`(?m)^\s*(const|let|var) \w+ = |function \w*\(|=> \{|^\s*(import .* from|export (default )?)`)},
+	{"sql", regexp.MustCompile(`(?i)^\s*(select .* from |insert into |create table |update \w+ set )`)},
+	{"ruby", regexp.MustCompile(`(?m)^\s*(require ['"]|def \w+.*\n[\s\S]*^\s*end$)`)},
+	{"shell", regexp.MustCompile(`(?m)^\s*(\$ |export \w+=|if \[ )`)},
+	{"yaml", regexp.MustCompile(`(?m)\A(---\s*\n)?(\w[\w-]*:( .*)?\n)+`)},
+	{"ini", regexp.MustCompile(`(?m)\A(\s*[#;].*\n)*\s*\[[\w. "-]+\]\s*\n\s*\w+\s*=`)},
+}
+
+// Detect guesses the language of text, returning nil if it looks like plain
+// text
+func Detect(text string) *Language {
+	if len(text) > detectLimit {
+		text = text[:detectLimit]
+	}
+
+	if strings.HasPrefix(text, "#!") {
+		line := text
+		if i := strings.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line[2:])
+		if len(fields) > 0 {
+			interp := fields[0][strings.LastIndexByte(fields[0], '/')+1:]
+			if interp == "env" && len(fields) > 1 {
+				interp = fields[1]
+			}
+			if name, ok := shebangs[interp]; ok {
+				return Lookup(name)
+			}
+		}
+	}
+
+	if trimmed := strings.TrimSpace(text); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		var v interface{}
+		if json.Unmarshal([]byte(trimmed), &v) == nil {
+			return Lookup("json")
+		}
+	}
+
+	for _, h := range hints {
+		if h.pattern.MatchString(text) {
+			return Lookup(h.lang)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// Package highlight renders text as syntax highlighted HTML, one line at a time
+package highlight
+
+import (
+	"html"
+	"html/template"
+	"strings"
+)
+
+// CSS classes given to highlighted tokens
+const (
+	ClassKeyword = "kw"
+	ClassString  = "str"
+	ClassComment = "com"
+	ClassNumber  = "num"
+	ClassInsert  = "ins"
+	ClassDelete  = "del"
+	ClassHunk    = "hunk"
+)
+
+// Lines splits text into lines and highlights each as lang. If lang is nil the
+// lines are only escaped
+func Lines(lang *Language, text string) []template.HTML {
+	b := &builder{}
+	if lang == nil {
+		b.write("", text)
+	} else if lang.Diff {
+		highlightDiff(b, text)
+	} else {
+		highlight(b, lang, text)
+	}
+	return b.finish()
+}
+
+func highlight(b *builder, lang *Language, text string) {
+	for i := 0; i < len(text); {
+		if n := lang.matchComment(text[i:]); n > 0 {
+			b.write(ClassComment, text[i:i+n])
+			i += n
+			continue
+		}
+		if n := lang.matchString(text[i:]); n > 0 {
+			b.write(ClassString, text[i:i+n])
+			i += n
+			continue
+		}
+
+		c := text[i]
+		wordStart := i == 0 || !isWord(text[i-1])
+		switch {
+		case wordStart && isDigit(c):
+			n := 1
+			for i+n < len(text) && (isWord(text[i+n]) || text[i+n] == '.') {
+				n++
+			}
+			b.write(ClassNumber, text[i:i+n])
+			i += n
+		case wordStart && isWord(c):
+			n := 1
+			for i+n < len(text) && isWord(text[i+n]) {
+				n++
+			}
+			word := text[i : i+n]
+			if lang.isKeyword(word) {
+				b.write(ClassKeyword, word)
+			} else {
+				b.write("", word)
+			}
+			i += n
+		default:
+			b.write("", text[i:i+1])
+			i++
+		}
+	}
+}
+
+func highlightDiff(b *builder, text string) {
+	for _, line := range strings.SplitAfter(text, "\n") {
+		class := ""
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			class = ClassKeyword
+		case strings.HasPrefix(line, "@@"):
+			class = ClassHunk
+		case strings.HasPrefix(line, "+"):
+			class = ClassInsert
+		case strings.HasPrefix(line, "-"):
+			class = ClassDelete
+		}
+		b.write(class, line)
+	}
+}
+
+// builder collects highlighted tokens into lines of HTML. Tokens which span
+// several lines are closed at the end of each line and reopened on the next
+type builder struct {
+	lines []template.HTML
+	line  []byte
+	// open is true once any text has been written to the current line, even
+	// if it was only a \r which is left out
+	open bool
+}
+
+func (b *builder) write(class, text string) {
+	for {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			b.span(class, text)
+			return
+		}
+		b.span(class, text[:i])
+		b.lines = append(b.lines, template.HTML(b.line))
+		b.line, b.open = nil, false
+		text = text[i+1:]
+	}
+}
+
+func (b *builder) span(class, text string) {
+	if text == "" {
+		return
+	}
+	b.open = true
+	text = strings.TrimSuffix(text, "\r")
+	if class == "" {
+		b.line = append(b.line, html.EscapeString(text)...)
+		return
+	}
+	b.line = append(b.line, `<span class="`+class+`">`...)
+	b.line = append(b.line, html.EscapeString(text)...)
+	b.line = append(b.line, "</span>"...)
+}
+
+func (b *builder) finish() []template.HTML {
+	// a trailing newline ends the last line rather than starting a new one
+	if b.open || len(b.lines) == 0 {
+		b.lines = append(b.lines, template.HTML(b.line))
+	}
+	return b.lines
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWord(c byte) bool {
+	return c == '_' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,276 @@
+package highlight
+
+import (
+	"sort"
+	"strings"
+)
+
+// Language describes the syntax of a language closely enough to highlight its
+// keywords, strings, comments and numbers
+type Language struct {
+	// Name is the canonical name of the language
+	Name string
+	// Aliases are other names and file extensions the language is known by
+	Aliases []string
+	// Keywords are highlighted wherever they appear as whole words
+	Keywords []string
+	// IgnoreCase matches keywords regardless of case
+	IgnoreCase bool
+	// LineComments start comments which run to the end of the line
+	LineComments []string
+	// BlockComments are pairs of delimiters which start and end comments
+	BlockComments [][2]string
+	// Strings are delimiters of strings which end at the end of the line and
+	// may contain backslash escapes
+	Strings []string
+	// RawStrings are delimiters of strings which may span lines
+	RawStrings []string
+	// Diff highlights whole lines as added or removed
+	Diff bool
+
+	keywords map[string]bool
+}
+
+func (l *Language) isKeyword(word string) bool {
+	if l.IgnoreCase {
+		word = strings.ToLower(word)
+	}
+	return l.keywords[word]
+}
+
+// matchComment returns the length of the comment at the start of text
+func (l *Language) matchComment(text string) int {
+	for _, d := range l.BlockComments {
+		if strings.HasPrefix(text, d[0]) {
+			end := strings.Index(text[len(d[0]):], d[1])
+			if end < 0 {
+				return len(text)
+			}
+			return len(d[0]) + end + len(d[1])
+		}
+	}
+	for _, d := range l.LineComments {
+		if strings.HasPrefix(text, d) {
+			end := strings.IndexByte(text, '\n')
+			if end < 0 {
+				return len(text)
+			}
+			return end
+		}
+	}
+	return 0
+}
+
+// matchString returns the length of the string literal at the start of text
+func (l *Language) matchString(text string) int {
+	for _, d := range l.RawStrings {
+		if strings.HasPrefix(text, d) {
+			end := strings.Index(text[len(d):], d)
+			if end < 0 {
+				return len(text)
+			}
+			return len(d) + end + len(d)
+		}
+	}
+	for _, d := range l.Strings {
+		if !strings.HasPrefix(text, d) {
+			continue
+		}
+		for i := len(d); i < len(text); i++ {
+			switch {
+			case text[i] == '\\':
+				i++
+			case text[i] == '\n':
+				return i
+			case strings.HasPrefix(text[i:], d):
+				return i + len(d)
+			}
+		}
+		return len(text)
+	}
+	return 0
+}
+
+var (
+	languages = []*Language{
+		{
+			Name:    "go",
+			Aliases: []string{"golang"},
+			Keywords: words(`break case chan const continue default defer else
+				fallthrough for func go goto if import interface map package range
+				return select struct switch type var true false nil iota`),
+			LineComments:  []string{"//"},
+			BlockComments: [][2]string{{"/*", "*/"}},
+			Strings:       []string{`"`, `'`},
+			RawStrings:    []string{"`"},
+		},
+		{
+			Name:    "python",
+			Aliases: []string{"py", "python3"},
+			Keywords: words(`and as assert async await break class continue def
+				del elif else except finally for from global if import in is
+				lambda nonlocal not or pass raise return try while with yield
+				True False None self`),
+			LineComments: []string{"#"},
+			Strings:      []string{`"`, `'`},
+			RawStrings:   []string{`"""`, `'''`},
+		},
+		{
+			Name:    "javascript",
+			Aliases: []string{"js", "typescript", "ts", "node"},
+			Keywords: words(`async await break case catch class const continue
+				debugger default delete do else export extends finally for from
+				function if import in instanceof let new of return super switch
+				this throw try typeof var void while yield true false null
+				undefined`),
+			LineComments:  []string{"//"},
+			BlockComments: [][2]string{{"/*", "*/"}},
+			Strings:       []string{`"`, `'`},
+			RawStrings:    []string{"`"},
+		},
+		{
+			Name:     "json",
+			Keywords: words(`true false null`),
+			Strings:  []string{`"`},
+		},
+		{
+			Name:    "shell",
+			Aliases: []string{"sh", "bash", "zsh", "shellscript"},
+			Keywords: words(`case do done elif else esac export fi for function
+				if in local readonly return select then until while`),
+			LineComments: []string{"#"},
+			Strings:      []string{`"`, `'`},
+		},
+		{
+			Name:    "c",
+			Aliases: []string{"h", "cpp", "c++", "cc", "hpp"},
+			Keywords: words(`auto break case char class const continue default
+				delete do double else enum extern float for goto if inline int
+				long namespace new private protected public register return short
+				signed sizeof static struct switch template this typedef union
+				unsigned using virtual void volatile while true false NULL
+				nullptr`),
+			LineComments:  []string{"//"},
+			BlockComments: [][2]string{{"/*", "*/"}},
+			Strings:       []string{`"`, `'`},
+		},
+		{
+			Name:    "java",
+			Aliases: []string{"kotlin", "kt"},
+			Keywords: words(`abstract boolean break byte case catch char class
+				const continue default do double else enum extends final finally
+				float for if implements import instanceof int interface long new
+				package private protected public return short static super switch
+				synchronized this throw throws try void volatile while true false
+				null`),
+			LineComments:  []string{"//"},
+			BlockComments: [][2]string{{"/*", "*/"}},
+			Strings:       []string{`"`, `'`},
+		},
+		{
+			Name:    "rust",
+			Aliases: []string{"rs"},
+			Keywords: words(`as async await break const continue crate dyn else
+				enum extern false fn for if impl in let loop match mod move mut
+				pub ref return self Self static struct super trait true type
+				unsafe use where while`),
+			LineComments:  []string{"//"},
+			BlockComments: [][2]string{{"/*", "*/"}},
+			Strings:       []string{`"`},
+		},
+		{
+			Name:    "ruby",
+			Aliases: []string{"rb"},
+			Keywords: words(`alias and begin break case class def defined do else
+				elsif end ensure false for if in module next nil not or redo
+				rescue retry return self super then true undef unless until when
+				while yield require`),
+			LineComments: []string{"#"},
+			Strings:      []string{`"`, `'`},
+		},
+		{
+			Name: "sql",
+			Keywords: words(`add all alter and as asc between by case create
+				delete desc distinct drop else end exists from group having in
+				index inner insert into is join key left like limit not null on
+				or order outer primary references right select set table then
+				union unique update values when where with`),
+			IgnoreCase:    true,
+			LineComments:  []string{"--"},
+			BlockComments: [][2]string{{"/*", "*/"}},
+			Strings:       []string{`'`, `"`},
+		},
+		{
+			Name:         "yaml",
+			Aliases:      []string{"yml"},
+			Keywords:     words(`true false null yes no on off`),
+			LineComments: []string{"#"},
+			Strings:      []string{`"`, `'`},
+		},
+		{
+			Name:         "ini",
+			Aliases:      []string{"toml", "conf", "cfg"},
+			Keywords:     words(`true false`),
+			LineComments: []string{"#", ";"},
+			Strings:      []string{`"`, `'`},
+		},
+		{
+			Name:    "dockerfile",
+			Aliases: []string{"docker"},
+			Keywords: words(`from run cmd label expose env add copy entrypoint
+				volume user workdir arg onbuild stopsignal healthcheck shell as`),
+			IgnoreCase:   true,
+			LineComments: []string{"#"},
+			Strings:      []string{`"`, `'`},
+		},
+		{
+			Name:          "html",
+			Aliases:       []string{"xml", "htm", "svg"},
+			BlockComments: [][2]string{{"<!--", "-->"}},
+			Strings:       []string{`"`, `'`},
+		},
+		{
+			Name:    "diff",
+			Aliases: []string{"patch"},
+			Diff:    true,
+		},
+	}
+
+	byName = make(map[string]*Language)
+)
+
+func init() {
+	for _, l := range languages {
+		l.keywords = make(map[string]bool, len(l.Keywords))
+		for _, k := range l.Keywords {
+			if l.IgnoreCase {
+				k = strings.ToLower(k)
+			}
+			l.keywords[k] = true
+		}
+		byName[l.Name] = l
+		for _, a := range l.Aliases {
+			byName[a] = l
+		}
+	}
+}
+
+// Lookup returns the language with the given name, alias or file extension, or
+// nil if there is none
+func Lookup(name string) *Language {
+	return byName[strings.TrimPrefix(strings.ToLower(name), ".")]
+}
+
+// Names returns the canonical names of all languages
+func Names() []string {
+	names := make([]string, len(languages))
+	for i, l := range languages {
+		names[i] = l.Name
+	}
+	sort.Strings(names)
+	return names
+}
+
+func words(s string) []string {
+	return strings.Fields(s)
+}
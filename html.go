@@ -35,3 +35,298 @@ var HTMLHelpTemplate = template.Must(template.New("help").Parse(`
 
 </html>
 `))
+
+var HTMLPasteTemplate = template.Must(template.New("paste").Parse(`
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{ .Name }} - icanhazpaste</title>
+  <link rel="stylesheet" href="/styles.css" />
+</head>
+<body class="paste">
+  <div class="paste-header">
+    <a href="/">icanhazpaste</a> / {{ .Name }}
+    <form method="get" class="paste-actions">
+      <select name="lang" onchange="this.form.submit()">
+        <option value="text">plain text</option>
+        {{ range .Languages }}<option value="{{ . }}"{{ if eq . $.Language }} selected="selected"{{ end }}>{{ . }}</option>
+        {{ end }}
+      </select>
+      <a href="{{ .Raw }}">raw</a>
+      {{ if .Revision }}<a href="/x/{{ .Name }}/history">revision {{ .Revision }}</a>{{ end }}
+      <span class="gray">expires {{ .Expires }}</span>
+    </form>
+    <form method="post" action="/x/{{ .Name }}/fork" class="paste-actions">
+      {{ if .Parent }}<span class="gray">forked from <a href="/x/{{ .Parent }}">{{ .Parent }}</a></span>{{ end }}
+      <input type="submit" class="link" value="fork" title="Copy this paste into a new one you can edit">
+    </form>
+  </div>
+
+  <table class="paste-lines">
+  {{ range .Lines }}<tr id="L{{ .Number }}" class="line"><td class="ln"><a href="#L{{ .Number }}">{{ .Number }}</a></td><td class="code"><pre>{{ .HTML }}</pre></td></tr>
+  {{ end }}
+  </table>
+
+<script type="text/javascript">
+(function() {
+  var anchor = null;
+
+  // highlight the lines named in the fragment, either #L10 or #L10-L20
+  function selectLines() {
+    var selected = document.querySelectorAll("tr.selected");
+    for (var i = 0; i < selected.length; i++) {
+      selected[i].className = "line";
+    }
+    var m = /^#L(\d+)(?:-L(\d+))?$/.exec(window.location.hash);
+    if (!m) {
+      return;
+    }
+    var from = +m[1], to = +(m[2] || m[1]);
+    if (from > to) {
+      var t = from; from = to; to = t;
+    }
+    for (var n = from; n <= to; n++) {
+      var row = document.getElementById("L" + n);
+      if (row) {
+        row.className = "line selected";
+      }
+    }
+    var first = document.getElementById("L" + from);
+    if (first) {
+      first.scrollIntoView();
+    }
+  }
+
+  // shift-clicking a line number selects the range from the last line clicked
+  document.addEventListener("click", function(e) {
+    var cell = e.target.parentNode;
+    if (!cell || cell.className !== "ln") {
+      return;
+    }
+    var n = +e.target.textContent;
+    if (e.shiftKey && anchor !== null) {
+      e.preventDefault();
+      window.location.hash = "#L" + Math.min(anchor, n) + "-L" + Math.max(anchor, n);
+      return;
+    }
+    anchor = n;
+  });
+
+  window.addEventListener("hashchange", selectLines);
+  selectLines();
+})();
+</script>
+</body>
+</html>
+`))
+
+var HTMLDecryptTemplate = template.Must(template.New("decrypt").Parse(`
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{ .Name }} - icanhazpaste</title>
+  <link rel="stylesheet" href="/styles.css" />
+</head>
+<body class="paste">
+  <div class="paste-header">
+    <a href="/">icanhazpaste</a> / {{ .Name }}
+    <span class="paste-actions gray">encrypted in the browser, expires {{ .Expires }}</span>
+  </div>
+
+  <pre id="paste" class="paste-decrypted" data-ciphertext="{{ .Ciphertext }}">decrypting...</pre>
+
+<script type="text/javascript">
+(function() {
+  var out = document.getElementById("paste");
+
+  function fail(msg) {
+    out.className = "paste-decrypted error";
+    out.textContent = msg;
+  }
+
+  function decode(s) {
+    s = s.replace(/-/g, "+").replace(/_/g, "/");
+    while (s.length % 4) {
+      s += "=";
+    }
+    var bin = atob(s), bytes = new Uint8Array(bin.length);
+    for (var i = 0; i < bin.length; i++) {
+      bytes[i] = bin.charCodeAt(i);
+    }
+    return bytes;
+  }
+
+  if (!window.crypto || !window.crypto.subtle) {
+    return fail("This browser cannot decrypt pastes. Pages must be served over https to use encryption.");
+  }
+  var key = window.location.hash.slice(1);
+  if (!key) {
+    return fail("The key to decrypt this paste is missing from the link.");
+  }
+
+  var parts = out.getAttribute("data-ciphertext").split(":");
+  try {
+    var iv = decode(parts[0]), ciphertext = decode(parts[1]), raw = decode(key);
+  } catch (e) {
+    return fail("This paste or its key is malformed.");
+  }
+
+  window.crypto.subtle.importKey("raw", raw, { name: "AES-GCM" }, false, ["decrypt"])
+    .then(function(k) {
+      return window.crypto.subtle.decrypt({ name: "AES-GCM", iv: iv }, k, ciphertext);
+    })
+    .then(function(plaintext) {
+      out.textContent = new TextDecoder().decode(plaintext);
+    })
+    .catch(function() {
+      fail("This paste could not be decrypted. Check that the link is complete.");
+    });
+})();
+</script>
+</body>
+</html>
+`))
+
+var HTMLBundleTemplate = template.Must(template.New("bundle").Parse(`
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{ .Name }} - icanhazpaste</title>
+  <link rel="stylesheet" href="/styles.css" />
+</head>
+<body class="paste">
+  <div class="paste-header">
+    <a href="/">icanhazpaste</a> / {{ .Name }}
+    <span class="paste-actions">
+      <a href="/x/{{ .Name }}.tar.gz">tar.gz</a>
+      <a href="/x/{{ .Name }}.zip">zip</a>
+      {{ if .Revision }}<a href="/x/{{ .Name }}/history">revision {{ .Revision }}</a>{{ end }}
+      <span class="gray">expires {{ .Expires }}</span>
+    </span>
+    <form method="post" action="/x/{{ .Name }}/fork" class="paste-actions">
+      {{ if .Parent }}<span class="gray">forked from <a href="/x/{{ .Parent }}">{{ .Parent }}</a></span>{{ end }}
+      <input type="submit" class="link" value="fork" title="Copy this paste into a new one you can edit">
+    </form>
+  </div>
+
+  <div class="bundle-tabs">
+  {{ range $i, $f := .Files }}<a href="#file-{{ $i }}" id="tab-{{ $i }}">{{ $f.Name }}</a>
+  {{ end }}
+  </div>
+
+  {{ range $i, $f := .Files }}<div class="bundle-file" id="file-{{ $i }}">
+    <div class="bundle-file-header"><a href="{{ $f.Path }}">{{ $f.Name }}</a> <span class="gray">{{ $f.Size }} bytes</span></div>
+    {{ if $f.Shown }}<table class="paste-lines">
+    {{ range $f.Lines }}<tr class="line"><td class="ln">{{ .Number }}</td><td class="code"><pre>{{ .HTML }}</pre></td></tr>
+    {{ end }}
+    </table>{{ else }}<p class="diff-message gray">This file is too large or is not text. <a href="{{ $f.Path }}">Download it</a>.</p>{{ end }}
+  </div>
+  {{ end }}
+
+<script type="text/javascript">
+(function() {
+  // show only the file named in the fragment, or the first file. without
+  // scripts every file is shown one after another
+  function selectFile() {
+    var files = document.querySelectorAll("div.bundle-file");
+    var m = /^#file-(\d+)$/.exec(window.location.hash);
+    var shown = m && document.getElementById("file-" + m[1]) ? +m[1] : 0;
+    for (var i = 0; i < files.length; i++) {
+      files[i].style.display = i === shown ? "" : "none";
+      document.getElementById("tab-" + i).className = i === shown ? "selected" : "";
+    }
+  }
+
+  window.addEventListener("hashchange", selectFile);
+  selectFile();
+})();
+</script>
+</body>
+</html>
+`))
+
+var HTMLHistoryTemplate = template.Must(template.New("history").Parse(`
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{ .Name }} history - icanhazpaste</title>
+  <link rel="stylesheet" href="/styles.css" />
+</head>
+<body class="paste">
+  <div class="paste-header">
+    <a href="/">icanhazpaste</a> / <a href="/x/{{ .Name }}">{{ .Name }}</a> / history
+    <span class="paste-actions gray">expires {{ .Expires }}</span>
+  </div>
+
+  <table class="paste-history">
+  {{ range .Revisions }}<tr><td><a href="/x/{{ $.Name }}/v/{{ .Revision }}">revision {{ .Revision }}</a></td><td class="gray">{{ .Size }} bytes</td><td class="gray">{{ .Created.Format "Mon, 02 Jan 2006 15:04:05 MST" }}</td></tr>
+  {{ end }}
+  </table>
+</body>
+</html>
+`))
+
+var HTMLDiffTemplate = template.Must(template.New("diff").Parse(`
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{ .A.Spec }} vs {{ .B.Spec }} - icanhazpaste</title>
+  <link rel="stylesheet" href="/styles.css" />
+</head>
+<body class="paste">
+  <div class="paste-header">
+    <a href="/">icanhazpaste</a> / diff
+    {{ if not .Binary }}<form method="get" class="paste-actions">
+      <select name="lang" onchange="this.form.submit()">
+        <option value="text">plain text</option>
+        {{ range .Languages }}<option value="{{ . }}"{{ if eq . $.Language }} selected="selected"{{ end }}>{{ . }}</option>
+        {{ end }}
+      </select>
+    </form>{{ end }}
+  </div>
+
+  {{ if .Identical }}<p class="diff-message gray">{{ .A.Spec }} and {{ .B.Spec }} are identical.</p>
+  {{ else if .Binary }}<p class="diff-message gray">{{ .A.Spec }} and {{ .B.Spec }} are not text and differ.</p>
+  {{ else }}<table class="paste-lines paste-diff">
+  <tr class="diff-names"><th colspan="2"><a href="{{ .A.Path }}">{{ .A.Spec }}</a></th><th colspan="2"><a href="{{ .B.Path }}">{{ .B.Spec }}</a></th></tr>
+  {{ range .Rows }}{{ if .Gap }}<tr class="diff-gap"><td class="ln">&hellip;</td><td></td><td class="ln">&hellip;</td><td></td></tr>
+  {{ else }}<tr><td class="ln">{{ if .A }}{{ .A }}{{ end }}</td><td class="code {{ .AOp }}"><pre>{{ .AHTML }}</pre></td><td class="ln">{{ if .B }}{{ .B }}{{ end }}</td><td class="code {{ .BOp }}"><pre>{{ .BHTML }}</pre></td></tr>
+  {{ end }}{{ end }}
+  </table>{{ end }}
+</body>
+</html>
+`))
+
+var HTMLPasswordTemplate = template.Must(template.New("password").Parse(`
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{ .Name }} - icanhazpaste</title>
+  <link rel="stylesheet" href="/styles.css" />
+</head>
+<body class="paste">
+  <div class="paste-header">
+    <a href="/">icanhazpaste</a> / {{ .Name }}
+    <span class="paste-actions gray">protected by a password</span>
+  </div>
+
+  <form method="post" class="paste-password" onsubmit="this.action = window.location.href">
+    {{ if .Wrong }}<p class="error">That password is not right.</p>{{ end }}
+    <label class="gray">Password <input type="password" name="password" required="required" autofocus="autofocus"></label>
+    <input type="submit" class="button" value="View">
+  </form>
+</body>
+</html>
+`))
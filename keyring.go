@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// sealKeySize is the length of the AES-256 keys pastes are sealed with
+	sealKeySize = 32
+	// sealVersion is the version of the envelope sealed pastes are stored in
+	sealVersion = 1
+)
+
+// sealMagic and the version start every sealed paste so they can be told
+// apart from pastes stored before encryption at rest was enabled. Pastes
+// stored since then by a CompressedStore start with compressMagic instead, so
+// only bodies stored before both were added can be mistaken for a sealed one
+var sealMagic = []byte("\x00ihp")
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// ErrUnknownKey is an error indicating a paste was sealed with a key which is
+// no longer in the keyring
+var ErrUnknownKey = fmt.Errorf("paste was sealed with an unknown key")
+
+// Keyring holds the keys pastes are encrypted with at rest. New pastes are
+// sealed with the primary key and the others are kept to open pastes which
+// were sealed before the keys were rotated
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// ParseKeyring parses keys written as id:base64 separated by commas, spaces or
+// newlines. Lines starting with # are ignored. The first key is the primary
+// key. Keys are 32 random bytes, e.g. from `head -c 32 /dev/urandom | base64`
+func ParseKeyring(text string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, entry := range strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r'
+		}) {
+			if err := k.add(entry); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if k.primary == "" {
+		return nil, errors.New("no encryption keys given")
+	}
+	return k, nil
+}
+
+// LoadKeyring reads a keyring from the file at path. See ParseKeyring for the
+// format
+func LoadKeyring(path string) (*Keyring, error) {
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read key file")
+	}
+	return ParseKeyring(string(text))
+}
+
+func (k *Keyring) add(entry string) error {
+	i := strings.IndexByte(entry, ':')
+	if i < 0 {
+		return errors.Errorf("encryption key %q must be written as id:base64", entry)
+	}
+	id, encoded := entry[:i], entry[i+1:]
+	if !keyIDPattern.MatchString(id) {
+		return errors.Errorf("invalid encryption key id %q", id)
+	}
+	if _, ok := k.keys[id]; ok {
+		return errors.Errorf("duplicate encryption key id %q", id)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return errors.Wrapf(err, "invalid encryption key %q", id)
+	}
+	if len(key) != sealKeySize {
+		return errors.Errorf("encryption key %q must be %d bytes", id, sealKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	k.keys[id] = aead
+	if k.primary == "" {
+		k.primary = id
+	}
+	return nil
+}
+
+// Primary returns the id of the key new pastes are sealed with
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// seal encrypts body with the primary key. The name of the paste is
+// authenticated with it so sealed bodies cannot be swapped between pastes. The
+// nonce is random so pastes with the same contents are not sealed the same way
+// and cannot be told apart or share storage
+//
+// the envelope is the magic bytes, the version, the length of the key id, the
+// key id, the nonce and then the ciphertext
+func (k *Keyring) seal(name string, body []byte) ([]byte, error) {
+	aead := k.keys[k.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "failed to generate nonce")
+	}
+
+	out := make([]byte, 0, len(sealMagic)+2+len(k.primary)+len(nonce)+len(body)+aead.Overhead())
+	out = append(out, sealMagic...)
+	out = append(out, sealVersion, byte(len(k.primary)))
+	out = append(out, k.primary...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, body, []byte(name)), nil
+}
+
+// open decrypts a body sealed by seal for the paste with the given name.
+// Bodies which are not sealed are returned as they are
+func (k *Keyring) open(name string, body []byte) ([]byte, error) {
+	if !bytes.HasPrefix(body, sealMagic) || len(body) == len(sealMagic) || body[len(sealMagic)] != sealVersion {
+		return body, nil
+	}
+	rest := body[len(sealMagic):]
+	if len(rest) < 2 || len(rest) < 2+int(rest[1]) {
+		return nil, errors.New("malformed sealed paste")
+	}
+	id := string(rest[2 : 2+rest[1]])
+	rest = rest[2+len(id):]
+
+	aead, ok := k.keys[id]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownKey, "key %q", id)
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, errors.New("malformed sealed paste")
+	}
+	nonce, ciphertext := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open sealed paste")
+	}
+	return plain, nil
+}
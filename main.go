@@ -2,69 +2,244 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"net"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/alicebob/miniredis"
 	"github.com/apex/log"
+	"github.com/blockloop/icanhazpaste/rand"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-redis/redis"
 	"github.com/kouhin/envflag"
+	"github.com/ulule/limiter"
+	"github.com/ulule/limiter/drivers/store/memory"
 )
 
 var (
-	debug      bool
-	redisAddr  string
-	listenAddr string
+	debug             bool
+	storeType         string
+	redisAddr         string
+	dataDir           string
+	sweepInterval     time.Duration
+	listenAddr        string
+	defaultTTL        time.Duration
+	minTTL            time.Duration
+	maxTTL            time.Duration
+	nameMode          string
+	nameLength        int
+	nameAlphabet      string
+	nameWords         int
+	ipHashKey         string
+	encryptionKeys    string
+	encryptionKeyFile string
+	compressMinSize   int
+	maxSize           int64
+	tcpAddr           string
+	tcpIdleTimeout    time.Duration
+	tcpReadTimeout    time.Duration
+	tcpMaxConns       int
+	publicURL         string
+	sshAddr           string
+	sshHostKey        string
+	sshAuthorizedKeys string
+	sshTimeout        time.Duration
+	sshMaxConns       int
+	trustedProxies    string
 )
 
 func init() {
 	flag.BoolVar(&debug, "debug", false, "Enable debug mode")
+	flag.StringVar(&storeType, "store", "redis", "Storage backend for pastes (redis, fs, memory)")
 	flag.StringVar(&redisAddr, "redis-url", "", "Redis address to connect to (empty address creates miniredis)")
+	flag.StringVar(&dataDir, "data-dir", "data", "Directory to write pastes to when using the fs store")
+	flag.DurationVar(&sweepInterval, "sweep-interval", time.Minute, "How often expired pastes are deleted when using the fs store")
 	flag.StringVar(&listenAddr, "listen-addr", ":3000", "Address to listen for HTTP requests")
+	flag.StringVar(&trustedProxies, "trusted-proxies", "", "Comma separated addresses or CIDR ranges of proxies whose X-Forwarded-For and X-Real-IP headers are trusted (empty trusts none)")
+	flag.StringVar(&tcpAddr, "tcp-addr", "", "Address to accept pastes sent over raw TCP, such as with nc (empty disables it)")
+	flag.DurationVar(&tcpIdleTimeout, "tcp-idle-timeout", DefaultTCPIdleTimeout, "How long a raw TCP paste waits for more data before it is stored")
+	flag.DurationVar(&tcpReadTimeout, "tcp-read-timeout", DefaultTCPReadTimeout, "How long a raw TCP paste may take to send all of its data")
+	flag.IntVar(&tcpMaxConns, "tcp-max-conns", DefaultTCPMaxConns, "How many raw TCP connections are handled at once")
+	flag.StringVar(&sshAddr, "ssh-addr", "", "Address to accept pastes and commands over SSH (empty disables it)")
+	flag.StringVar(&sshHostKey, "ssh-host-key", "", "File to read the private key the SSH server identifies itself with (empty generates one at startup)")
+	flag.StringVar(&sshAuthorizedKeys, "ssh-authorized-keys", "", "authorized_keys file of the only keys which may connect over SSH, with their owners as comments (empty allows anyone)")
+	flag.DurationVar(&sshTimeout, "ssh-timeout", DefaultSSHTimeout, "How long an SSH connection may last")
+	flag.IntVar(&sshMaxConns, "ssh-max-conns", DefaultSSHMaxConns, "How many SSH connections are handled at once")
+	flag.StringVar(&publicURL, "public-url", "", "URL the server is reached at, used to link to raw TCP and SSH pastes (empty uses the listen address)")
+	flag.Int64Var(&maxSize, "max-size", DefaultMaxSize, "Size in bytes of the largest paste which is accepted")
+	flag.DurationVar(&defaultTTL, "default-ttl", DefaultFileTTL, "How long pastes are kept when they do not choose a TTL")
+	flag.DurationVar(&minTTL, "min-ttl", DefaultMinTTL, "Shortest TTL a paste may choose")
+	flag.DurationVar(&maxTTL, "max-ttl", DefaultMaxTTL, "Longest TTL a paste may choose")
+	flag.StringVar(&nameMode, "name-mode", "random", "How paste names are generated (random, short, words)")
+	flag.IntVar(&nameLength, "name-length", DefaultNameLength, "Number of characters in random paste names")
+	flag.StringVar(&nameAlphabet, "name-alphabet", rand.Alphanumeric, "Characters random paste names are made of")
+	flag.IntVar(&nameWords, "name-words", 3, "Number of words in words paste names")
+	flag.StringVar(&ipHashKey, "ip-hash-key", "", "Secret key for hashing the addresses of paste creators and the owners of SSH keys (empty generates one at startup)")
+	flag.StringVar(&encryptionKeys, "encryption-keys", "", "Keys to encrypt pastes at rest with, as comma separated id:base64 pairs. The first key encrypts new pastes")
+	flag.StringVar(&encryptionKeyFile, "encryption-key-file", "", "File to read the keys to encrypt pastes at rest with, one id:base64 pair per line")
+	flag.IntVar(&compressMinSize, "compress-min-size", DefaultCompressMinSize, "Smallest paste in bytes which is compressed before it is stored (0 disables compression)")
 }
 
 func main() {
+	// the binary is the command line client when run with one of its commands
+	if len(os.Args) > 1 {
+		if _, ok := commands[os.Args[1]]; ok {
+			os.Exit(runCommand(os.Args[1], os.Args[2:]))
+		}
+	}
+
 	if err := envflag.Parse(); err != nil {
 		log.WithError(err).Fatal("failed to parse flags")
 	}
-	if redisAddr == "" {
-		srv, err := miniredis.Run()
+	var (
+		store        Store
+		limiterStore limiter.Store
+	)
+	switch storeType {
+	case "memory":
+		store = NewMemoryStore(defaultTTL)
+		limiterStore = memory.NewStore()
+		log.Info("using in-memory store")
+	case "fs":
+		fs, err := NewFileStore(dataDir, defaultTTL)
 		if err != nil {
-			log.WithError(err).Fatal("failed to start miniredis")
+			log.WithError(err).WithField("dir", dataDir).Fatal("failed to open fs store")
+		}
+		fs.StartSweeper(sweepInterval)
+		defer fs.Close()
+
+		store = fs
+		limiterStore = memory.NewStore()
+		log.WithField("dir", dataDir).Info("using fs store")
+	case "redis":
+		if redisAddr == "" {
+			log.Warn("no redis-url given, starting miniredis. pastes will be lost on restart")
+			srv, err := miniredis.Run()
+			if err != nil {
+				log.WithError(err).Fatal("failed to start miniredis")
+			}
+			redisAddr = srv.Addr()
+			defer srv.Close()
 		}
-		redisAddr = srv.Addr()
-		defer srv.Close()
+		ll := log.WithField("redis", redisAddr)
+
+		redisClient, err := connectRedis(redisAddr)
+		if err != nil {
+			ll.WithError(err).Fatal("failed to parse redis URL")
+		}
+		defer redisClient.Close()
+		ll.Info("connected to redis")
+
+		store = NewRedisStoreTTL(redisClient, defaultTTL)
+		limiterStore = newRedisLimiterStore(redisClient)
+	default:
+		log.WithField("store", storeType).Fatal("unknown store")
+	}
+
+	keyring, err := loadKeyring()
+	if err != nil {
+		log.WithError(err).Fatal("invalid encryption keys")
+	}
+	if keyring != nil {
+		store = NewSealedStore(store, keyring)
+		log.WithField("key", keyring.Primary()).Info("encrypting pastes at rest")
 	}
-	ll := log.WithField("redis", redisAddr)
+	// pastes are compressed before they are encrypted as ciphertext does not
+	// compress. The store is used even when compression is disabled so every
+	// chunk is marked with how it was stored
+	store = NewCompressedStore(store, compressMinSize)
 
-	redisClient, err := connectRedis(redisAddr)
+	proxies, err := ParseTrustedProxies(trustedProxies)
 	if err != nil {
-		ll.WithError(err).Fatal("failed to parse redis URL")
+		log.WithError(err).Fatal("invalid trusted proxies")
 	}
-	defer redisClient.Close()
-	ll.Info("connected to redis")
 
 	mux := chi.NewMux()
 	mux.Use(
-		maxContentLength(1<<20), // 1MB
-		middleware.RealIP,
+		maxContentLength(maxSize),
+		realIP(proxies),
 		middleware.RequestID,
-		middleware.Timeout(time.Second*10),
+		exceptUploads(middleware.Timeout(time.Second*10)),
 		middleware.Logger,
 		middleware.Recoverer,
 	)
 
-	handler := NewHandler(redisClient)
+	handler := NewHandler(store, limiterStore)
+	if err := handler.SetTTLLimits(defaultTTL, minTTL, maxTTL); err != nil {
+		log.WithError(err).Fatal("invalid ttl limits")
+	}
+	names, err := newNameGenerator(nameMode)
+	if err != nil {
+		log.WithError(err).Fatal("invalid paste name settings")
+	}
+	handler.SetNameGenerator(names)
+	handler.SetMaxSize(maxSize)
+	if ipHashKey != "" {
+		handler.SetIPHashKey(ipHashKey)
+	}
 	handler.RegisterRoutes(mux)
 
+	if tcpAddr != "" {
+		tcp := NewTCPServer(handler, publicBaseURL())
+		tcp.SetIdleTimeout(tcpIdleTimeout)
+		tcp.SetReadTimeout(tcpReadTimeout)
+		tcp.SetMaxConns(tcpMaxConns)
+		go func() {
+			log.WithError(tcp.ListenAndServe(tcpAddr)).Fatal("TCP server failed")
+		}()
+		log.WithField("address", tcpAddr).Info("TCP server starting")
+	}
+
+	if sshAddr != "" {
+		if sshHostKey == "" {
+			log.Warn("no ssh-host-key given, generating one. clients will see it change on restart")
+		}
+		hostKey, err := LoadHostKey(sshHostKey)
+		if err != nil {
+			log.WithError(err).WithField("file", sshHostKey).Fatal("failed to load SSH host key")
+		}
+		sshServer := NewSSHServer(handler, publicBaseURL(), hostKey)
+		sshServer.SetTimeout(sshTimeout)
+		sshServer.SetMaxConns(sshMaxConns)
+		if sshAuthorizedKeys != "" {
+			keys, err := LoadAuthorizedKeys(sshAuthorizedKeys)
+			if err != nil {
+				log.WithError(err).Fatal("failed to load SSH authorized keys")
+			}
+			sshServer.SetAuthorizedKeys(keys)
+			log.WithField("keys", len(keys)).Info("only authorized keys may paste over SSH")
+		}
+		go func() {
+			log.WithError(sshServer.ListenAndServe(sshAddr)).Fatal("SSH server failed")
+		}()
+		log.WithField("address", sshAddr).Info("SSH server starting")
+	}
+
 	log.WithField("address", listenAddr).Info("HTTP server starting")
-	log.WithError(http.ListenAndServe(":3000", mux)).
+	log.WithError(http.ListenAndServe(listenAddr, mux)).
 		Error("shutting down")
 }
 
+// publicBaseURL returns the URL the server is reached at from the public-url
+// flag, or the listen address if it is not given
+func publicBaseURL() string {
+	if publicURL != "" {
+		return strings.TrimSuffix(publicURL, "/")
+	}
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return "http://" + listenAddr
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
 func connectRedis(addr string) (*redis.Client, error) {
 	// Create a redis client.
 	prefix := "redis://"
@@ -79,6 +254,62 @@ func connectRedis(addr string) (*redis.Client, error) {
 	return redis.NewClient(option), nil
 }
 
+// loadKeyring loads the keys pastes are encrypted with at rest from the
+// encryption-keys flag or the encryption-key-file flag. It returns nil if
+// neither is given
+func loadKeyring() (*Keyring, error) {
+	switch {
+	case encryptionKeys != "" && encryptionKeyFile != "":
+		return nil, fmt.Errorf("encryption-keys and encryption-key-file cannot both be given")
+	case encryptionKeys != "":
+		return ParseKeyring(encryptionKeys)
+	case encryptionKeyFile != "":
+		return LoadKeyring(encryptionKeyFile)
+	}
+	return nil, nil
+}
+
+func newNameGenerator(mode string) (rand.Generator, error) {
+	switch mode {
+	case "random":
+		return rand.NewCharset(nameAlphabet, nameLength)
+	case "short":
+		return rand.NewShort(), nil
+	case "words":
+		return rand.NewWords(nameWords, "-")
+	}
+	return nil, fmt.Errorf("unknown name mode %q", mode)
+}
+
+// exceptUploads applies mw to every request except those to the routes which
+// upload a paste, which is streamed into the store for as long as that takes
+func exceptUploads(mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isUpload(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			wrapped.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isUpload reports whether r is to one of the routes which upload a paste:
+// POST /, PUT /x/{name} and POST /api/v1/pastes. Other routes which accept a
+// POST, such as password prompts, only read pastes
+func isUpload(r *http.Request) bool {
+	path := r.URL.Path
+	switch r.Method {
+	case http.MethodPost:
+		return path == "/" || path == "/api/v1/pastes"
+	case http.MethodPut:
+		return strings.HasPrefix(path, "/x/") && !strings.Contains(path[len("/x/"):], "/")
+	}
+	return false
+}
+
 func maxContentLength(max int64) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,219 @@
+package main
+
+// openAPIDocument describes the JSON API served under /api/v1
+const openAPIDocument = `{
+  "openapi": "3.0.0",
+  "info": {
+    "title": "icanhazpaste",
+    "description": "Create, read and delete pastes.",
+    "version": "1.0.0"
+  },
+  "servers": [{"url": "/api/v1"}],
+  "paths": {
+    "/pastes": {
+      "post": {
+        "summary": "Create a paste",
+        "description": "Send a CreatePasteRequest as JSON of at most 2MB, or the exact bytes of the paste with any other content type and the options in the query string or X-Paste-* headers. Larger pastes must be sent as their exact bytes.",
+        "operationId": "createPaste",
+        "parameters": [
+          {"name": "name", "in": "query", "schema": {"type": "string"}},
+          {"name": "filename", "in": "query", "schema": {"type": "string"}},
+          {"name": "lang", "in": "query", "schema": {"type": "string"}},
+          {"name": "ttl", "in": "query", "schema": {"type": "string"}, "example": "7d"},
+          {"name": "burn", "in": "query", "schema": {"type": "boolean"}},
+          {"name": "edit", "in": "query", "schema": {"type": "boolean"}},
+          {"name": "format", "in": "query", "schema": {"type": "string", "enum": ["plain", "encrypted"]}},
+          {"name": "X-Paste-Password", "in": "header", "schema": {"type": "string"}},
+          {"name": "X-Owner-Token", "in": "header", "description": "The owner token of earlier pastes to list this one with. A new token is made if it is not given", "schema": {"type": "string"}}
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {"schema": {"$ref": "#/components/schemas/CreatePasteRequest"}},
+            "application/octet-stream": {"schema": {"type": "string", "format": "binary"}}
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "The paste was created",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/CreatedPaste"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "get": {
+        "summary": "List your pastes",
+        "description": "Lists the pastes which have not expired created with an owner token, newest first.",
+        "operationId": "listPastes",
+        "parameters": [
+          {"name": "X-Owner-Token", "in": "header", "required": true, "description": "The owner_token the pastes were created with", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Your pastes",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PasteList"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pastes/{name}": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "get": {
+        "summary": "Get a paste",
+        "description": "Gets a paste and its content. Pastes which burn are deleted once they are read. Pastes larger than 1MB must be read from /pastes/{name}/raw.",
+        "operationId": "getPaste",
+        "parameters": [{"$ref": "#/components/parameters/Password"}],
+        "responses": {
+          "200": {
+            "description": "The paste",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Paste"}}}
+          },
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Delete a paste",
+        "operationId": "deletePaste",
+        "parameters": [
+          {"name": "X-Delete-Token", "in": "header", "schema": {"type": "string"}},
+          {"name": "token", "in": "query", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "204": {"description": "The paste was deleted"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pastes/{name}/info": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "get": {
+        "summary": "Get the metadata of a paste",
+        "description": "Gets a paste without its content. Pastes which burn are not deleted.",
+        "operationId": "getPasteInfo",
+        "responses": {
+          "200": {
+            "description": "The paste without its content",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Paste"}}}
+          },
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pastes/{name}/raw": {
+      "parameters": [{"$ref": "#/components/parameters/Name"}],
+      "get": {
+        "summary": "Get the exact bytes of a paste",
+        "operationId": "getPasteRaw",
+        "parameters": [{"$ref": "#/components/parameters/Password"}],
+        "responses": {
+          "200": {
+            "description": "The paste as it was sent",
+            "content": {"application/octet-stream": {"schema": {"type": "string", "format": "binary"}}}
+          },
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "parameters": {
+      "Name": {"name": "name", "in": "path", "required": true, "schema": {"type": "string"}},
+      "Password": {"name": "X-Paste-Password", "in": "header", "description": "The password of a protected paste. Basic auth is also accepted", "schema": {"type": "string"}}
+    },
+    "responses": {
+      "Error": {
+        "description": "The request failed",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ErrorResponse"}}}
+      }
+    },
+    "schemas": {
+      "CreatePasteRequest": {
+        "type": "object",
+        "required": ["content"],
+        "properties": {
+          "content": {"type": "string"},
+          "encoding": {"type": "string", "enum": ["utf-8", "base64"], "default": "utf-8"},
+          "name": {"type": "string", "pattern": "^[A-Za-z0-9][A-Za-z0-9_-]{2,63}$"},
+          "filename": {"type": "string"},
+          "language": {"type": "string"},
+          "ttl": {"type": "string", "example": "7d"},
+          "burn": {"type": "boolean"},
+          "password": {"type": "string"},
+          "editable": {"type": "boolean"},
+          "format": {"type": "string", "enum": ["plain", "encrypted"]},
+          "owner_token": {"type": "string"}
+        }
+      },
+      "Paste": {
+        "type": "object",
+        "required": ["name", "size", "created", "expires", "burn", "revision", "url", "raw_url"],
+        "properties": {
+          "name": {"type": "string"},
+          "size": {"type": "integer", "format": "int64"},
+          "created": {"type": "string", "format": "date-time"},
+          "expires": {"type": "string", "format": "date-time"},
+          "burn": {"type": "boolean"},
+          "content_type": {"type": "string"},
+          "filename": {"type": "string"},
+          "language": {"type": "string"},
+          "creator": {"type": "string"},
+          "creator_ip": {"type": "string"},
+          "format": {"type": "string", "enum": ["encrypted", "bundle"]},
+          "protected": {"type": "boolean"},
+          "editable": {"type": "boolean"},
+          "parent": {"type": "string"},
+          "revision": {"type": "integer"},
+          "url": {"type": "string"},
+          "raw_url": {"type": "string"},
+          "content": {"type": "string"},
+          "encoding": {"type": "string", "enum": ["utf-8", "base64"]}
+        }
+      },
+      "CreatedPaste": {
+        "allOf": [
+          {"$ref": "#/components/schemas/Paste"},
+          {
+            "type": "object",
+            "required": ["delete_token", "owner_token"],
+            "properties": {
+              "delete_token": {"type": "string"},
+              "edit_token": {"type": "string"},
+              "owner_token": {"type": "string"}
+            }
+          }
+        ]
+      },
+      "PasteList": {
+        "type": "object",
+        "required": ["pastes"],
+        "properties": {
+          "pastes": {"type": "array", "items": {"$ref": "#/components/schemas/Paste"}}
+        }
+      },
+      "ErrorResponse": {
+        "type": "object",
+        "required": ["error"],
+        "properties": {
+          "error": {
+            "type": "object",
+            "required": ["status", "code", "message"],
+            "properties": {
+              "status": {"type": "integer"},
+              "code": {"type": "string", "enum": ["bad_request", "unauthorized", "forbidden", "not_found", "method_not_allowed", "conflict", "too_large", "rate_limited", "internal"]},
+              "message": {"type": "string"}
+            }
+          }
+        }
+      }
+    }
+  }
+}
+`
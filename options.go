@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blockloop/icanhazpaste/highlight"
+)
+
+const (
+	// DefaultMinTTL is the default shortest time a paste may choose to be kept
+	DefaultMinTTL = time.Minute
+	// DefaultMaxTTL is the default longest time a paste may choose to be kept
+	DefaultMaxTTL = time.Hour * 24 * 30
+
+	day  = time.Hour * 24
+	week = day * 7
+)
+
+// pasteOptions reads the options for a new paste from the submitted html form,
+// the query string, or the request headers, in that order. Passwords are only
+// read from the form or headers
+func (h *Handler) pasteOptions(r *http.Request, form url.Values) (PasteOptions, error) {
+	opts := PasteOptions{
+		Burn:     truthy(option(r, form, "burn", "X-Paste-Burn")),
+		Filename: cleanFilename(option(r, form, "filename", "X-Paste-Filename")),
+	}
+
+	switch v := option(r, form, "format", "X-Paste-Format"); v {
+	case "", "plain":
+	case FormatEncrypted:
+		opts.Format = FormatEncrypted
+	case FormatBundle:
+		opts.Format = FormatBundle
+	default:
+		return opts, fmt.Errorf("unknown format %q", v)
+	}
+
+	if v := option(r, form, "lang", "X-Paste-Language"); v != "" {
+		lang := highlight.Lookup(v)
+		if lang == nil {
+			return opts, fmt.Errorf("unknown language %q", v)
+		}
+		opts.Language = lang.Name
+	}
+
+	ttl, err := h.requestTTL(r, form)
+	if err != nil {
+		return opts, err
+	}
+	opts.TTL = ttl
+
+	if v := newPassword(r, form); v != "" {
+		hash, err := hashPassword(v)
+		if err != nil {
+			return opts, err
+		}
+		opts.PasswordHash = hash
+	}
+	return opts, nil
+}
+
+// requestTTL reads the TTL chosen for a new paste, or returns the default TTL
+// if none was chosen
+func (h *Handler) requestTTL(r *http.Request, form url.Values) (time.Duration, error) {
+	v := option(r, form, "ttl", "X-Paste-TTL")
+	if v == "" {
+		return h.defaultTTL, nil
+	}
+	ttl, err := parseTTL(v)
+	if err != nil {
+		return 0, err
+	}
+	if ttl < h.minTTL || ttl > h.maxTTL {
+		return 0, fmt.Errorf("ttl must be between %s and %s", formatTTL(h.minTTL), formatTTL(h.maxTTL))
+	}
+	return ttl, nil
+}
+
+// describePaste records what a new paste contains, which is not chosen by its
+// author
+func (h *Handler) describePaste(contentType string, body []byte, opts *PasteOptions) {
+	if opts.Format == FormatEncrypted {
+		// there is nothing to learn from ciphertext
+		opts.ContentType = "text/plain; charset=utf-8"
+		opts.Language = ""
+		return
+	}
+	if opts.Format == FormatBundle {
+		// the files of a bundle are highlighted one at a time as they are shown
+		opts.ContentType = "application/x-tar"
+		opts.Language = ""
+		return
+	}
+
+	opts.ContentType = detectContentType(contentType, opts.Filename, body)
+	if opts.Language == "" && isText(opts.ContentType) {
+		lang := highlight.Lookup(filepath.Ext(opts.Filename))
+		if lang == nil {
+			lang = highlight.Detect(string(body))
+		}
+		if lang != nil {
+			opts.Language = lang.Name
+		}
+	}
+}
+
+// slugPattern matches the names which may be requested for a paste
+var slugPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{2,63}$`)
+
+// validSlug reports whether name may be requested as the name of a paste
+func validSlug(name string) bool {
+	return slugPattern.MatchString(name)
+}
+
+// option returns the first non-empty value of field in the form or query string
+// or of the header
+func option(r *http.Request, form url.Values, field, header string) string {
+	if v := form.Get(field); v != "" {
+		return v
+	}
+	if v := r.URL.Query().Get(field); v != "" {
+		return v
+	}
+	return r.Header.Get(header)
+}
+
+// truthy reports whether an option value enables a setting
+func truthy(v string) bool {
+	switch strings.ToLower(v) {
+	case "1", "on", "yes", "true":
+		return true
+	}
+	return false
+}
+
+// parseTTL parses a duration such as 90s, 10m or 7d. A bare number is taken to
+// be seconds
+func parseTTL(v string) (time.Duration, error) {
+	v = strings.TrimSpace(v)
+	if n, err := strconv.Atoi(v); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	if n := len(v); n > 1 {
+		unit := time.Duration(0)
+		switch v[n-1] {
+		case 'd':
+			unit = day
+		case 'w':
+			unit = week
+		}
+		if unit > 0 {
+			if i, err := strconv.Atoi(v[:n-1]); err == nil {
+				return time.Duration(i) * unit, nil
+			}
+		}
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ttl %q", v)
+	}
+	return d, nil
+}
+
+// formatTTL formats a duration in the largest whole unit parseTTL accepts
+func formatTTL(d time.Duration) string {
+	switch {
+	case d%week == 0:
+		return strconv.FormatInt(int64(d/week), 10) + "w"
+	case d%day == 0:
+		return strconv.FormatInt(int64(d/day), 10) + "d"
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	case d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	}
+	return d.String()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ulule/limiter"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordCost is the bcrypt cost used to hash paste passwords
+const passwordCost = bcrypt.DefaultCost
+
+// passwordRate is how many wrong passwords may be given from each address, and
+// for each paste, before further attempts are refused without being checked
+var passwordRate = limiter.Rate{
+	Limit:  10,
+	Period: 15 * time.Minute,
+}
+
+// ErrPasswordAttempts is an error indicating too many wrong passwords were
+// given for a paste or from an address
+var ErrPasswordAttempts = fmt.Errorf("too many wrong passwords, try again later")
+
+// hashPassword returns the slow hash of a paste password which is stored in its
+// place
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// checkPassword reports whether password matches the stored hash
+func checkPassword(password, hash string) bool {
+	if password == "" || hash == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// verifyPassword checks the password given for a paste from addr like
+// checkPassword, but only until too many wrong passwords have been given from
+// addr or for the paste, after which ErrPasswordAttempts is returned before
+// the password is hashed. Only wrong passwords count towards the limit
+func (h *Handler) verifyPassword(addr, name, password, hash string) (bool, error) {
+	if password == "" || hash == "" {
+		return false, nil
+	}
+	ctx := context.Background()
+	keys := []string{"password:addr:" + ipKey(addr), "password:paste:" + name}
+	for _, key := range keys {
+		rate, err := h.attempts.Peek(ctx, key)
+		if err != nil {
+			return false, err
+		}
+		// peeking does not count an attempt, so the limit is reached once
+		// none remain
+		if rate.Remaining == 0 {
+			return false, ErrPasswordAttempts
+		}
+	}
+	if checkPassword(password, hash) {
+		return true, nil
+	}
+	for _, key := range keys {
+		if _, err := h.attempts.Get(ctx, key); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// newPassword reads the password chosen for a new paste from the submitted
+// form or the X-Paste-Password header. It is never read from the query string
+// so it does not end up in logs
+func newPassword(r *http.Request, form url.Values) string {
+	if v := form.Get("password"); v != "" {
+		return v
+	}
+	return r.Header.Get("X-Paste-Password")
+}
+
+// requestPassword reads the password given to read a paste from the
+// X-Paste-Password header, basic auth, or the form posted from the password
+// prompt, in that order
+func requestPassword(r *http.Request) string {
+	if v := r.Header.Get("X-Paste-Password"); v != "" {
+		return v
+	}
+	if _, v, ok := r.BasicAuth(); ok && v != "" {
+		return v
+	}
+	if r.Method == http.MethodPost {
+		return r.PostFormValue("password")
+	}
+	return ""
+}
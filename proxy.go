@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseTrustedProxies parses comma separated addresses and CIDR ranges of the
+// proxies whose forwarding headers are trusted
+func ParseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy range %q", v)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// realIP sets the remote address of requests from a trusted proxy to the client
+// it forwarded them for, from X-Forwarded-For or else X-Real-IP. The headers of
+// anyone else are ignored, as they may say whatever they like
+func realIP(trusted []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isTrusted(trusted, r.RemoteAddr) {
+				if ip := forwardedIP(r, trusted); ip != "" {
+					r.RemoteAddr = ip
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedIP returns the address a trusted proxy forwarded r for. Each proxy
+// appends to X-Forwarded-For, so it is read from the end and the first address
+// which is not another trusted proxy is the client
+func forwardedIP(r *http.Request, trusted []*net.IPNet) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		addrs := strings.Split(xff, ",")
+		for i := len(addrs) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(addrs[i]))
+			if ip == nil {
+				return ""
+			}
+			if !isTrusted(trusted, ip.String()) {
+				return ip.String()
+			}
+		}
+		return ""
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// isTrusted reports whether the host of addr is one of the trusted proxies
+func isTrusted(trusted []*net.IPNet, addr string) bool {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package rand
+
+import (
+	crand "crypto/rand"
+	"errors"
+)
+
+const (
+	// Alphanumeric is the alphabet of upper and lower case letters and digits
+	Alphanumeric = letterBytes
+	// ShortLength is the number of characters in names generated by NewShort
+	ShortLength = 7
+)
+
+// Charset generates random strings of a fixed length from an alphabet using
+// a cryptographically secure source of randomness. Every character of the
+// alphabet is equally likely at each position
+type Charset struct {
+	alphabet string
+	length   int
+	mask     byte
+}
+
+// NewCharset creates a Charset generating strings with length characters chosen
+// from alphabet. The alphabet must contain between 2 and 256 characters
+func NewCharset(alphabet string, length int) (*Charset, error) {
+	if len(alphabet) < 2 || len(alphabet) > 256 {
+		return nil, errors.New("alphabet must contain between 2 and 256 characters")
+	}
+	if length <= 0 {
+		return nil, errors.New("length must be greater than zero")
+	}
+
+	// the smallest mask which can index every character of the alphabet
+	mask := byte(1)
+	for int(mask) < len(alphabet)-1 {
+		mask = mask<<1 | 1
+	}
+	return &Charset{
+		alphabet: alphabet,
+		length:   length,
+		mask:     mask,
+	}, nil
+}
+
+// NewShort creates a Charset generating short base62 names
+func NewShort() *Charset {
+	g, _ := NewCharset(Alphanumeric, ShortLength)
+	return g
+}
+
+// String implements Generator
+func (g *Charset) String() (string, error) {
+	b := make([]byte, g.length)
+	buf := make([]byte, g.length*2)
+	for i := 0; i < len(b); {
+		if _, err := crand.Read(buf); err != nil {
+			return "", err
+		}
+		// indices outside of the alphabet are discarded rather than wrapped
+		// around so no character is favored
+		for _, r := range buf {
+			if idx := int(r & g.mask); idx < len(g.alphabet) {
+				b[i] = g.alphabet[idx]
+				i++
+				if i == len(b) {
+					break
+				}
+			}
+		}
+	}
+	return string(b), nil
+}
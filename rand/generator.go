@@ -0,0 +1,7 @@
+package rand
+
+// Generator generates random names
+type Generator interface {
+	// String returns a newly generated name
+	String() (string, error)
+}
@@ -14,7 +14,8 @@ const (
 	letterIdxMax  = 63 / letterIdxBits   // # of letter indices fitting in 63 bits
 )
 
-// String returns a randomly generated string
+// String returns a randomly generated string. It is not suitable for names
+// which must not be guessed; use a Generator for those
 func String(n int) string {
 	b := make([]byte, n)
 	// A src.Int63() generates 63 random bits, enough for letterIdxMax characters!
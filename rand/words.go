@@ -0,0 +1,93 @@
+package rand
+
+import (
+	crand "crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Words generates pronounceable names such as brave-otter-lamp from a list of
+// adjectives followed by nouns using a cryptographically secure source of
+// randomness. Names are much easier to read aloud than a Charset but are also
+// far easier to guess, so they should not be relied upon to keep pastes private
+type Words struct {
+	count int
+	sep   string
+}
+
+// NewWords creates a Words generating names of count words joined by sep. The
+// first word is an adjective and the rest are nouns
+func NewWords(count int, sep string) (*Words, error) {
+	if count <= 0 {
+		return nil, errors.New("word count must be greater than zero")
+	}
+	return &Words{
+		count: count,
+		sep:   sep,
+	}, nil
+}
+
+// String implements Generator
+func (g *Words) String() (string, error) {
+	words := make([]string, g.count)
+	for i := range words {
+		list := nouns
+		if i == 0 && g.count > 1 {
+			list = adjectives
+		}
+		n, err := crand.Int(crand.Reader, big.NewInt(int64(len(list))))
+		if err != nil {
+			return "", err
+		}
+		words[i] = list[n.Int64()]
+	}
+	return strings.Join(words, g.sep), nil
+}
+
+var adjectives = []string{
+	"able", "agile", "amber", "ample", "ancient", "arctic", "azure", "bold",
+	"brave", "breezy", "bright", "brisk", "calm", "candid", "careful",
+	"cheerful", "clever", "cosmic", "cozy", "crimson", "crisp", "curious",
+	"daring", "dashing", "deep", "eager", "early", "earnest", "easy",
+	"electric", "elegant", "epic", "fair", "fancy", "fast", "fearless",
+	"fierce", "fine", "firm", "fluffy", "fond", "frosty", "gentle", "giant",
+	"gifted", "glad", "gleaming", "golden", "graceful", "grand", "great",
+	"green", "happy", "hardy", "hasty", "hidden", "honest", "humble", "icy",
+	"jolly", "jovial", "keen", "kind", "lively", "lofty", "loud", "loyal",
+	"lucky", "lunar", "magic", "mellow", "merry", "mighty", "misty", "modest",
+	"neat", "nimble", "noble", "odd", "olive", "orange", "patient", "peaceful",
+	"plucky", "polite", "proud", "purple", "quick", "quiet", "rapid", "rare",
+	"ready", "regal", "rosy", "royal", "rustic", "safe", "sandy", "savvy",
+	"scarlet", "serene", "sharp", "shiny", "silent", "silver", "simple",
+	"sleek", "smart", "smooth", "snowy", "solar", "solid", "sparkly", "speedy",
+	"spicy", "steady", "stellar", "stormy", "sturdy", "sunny", "super", "swift",
+	"tame", "tidy", "tiny", "tranquil", "trusty", "upbeat", "valiant", "vast",
+	"velvet", "vivid", "warm", "wild", "wise", "witty", "young", "zany",
+	"zesty",
+}
+
+var nouns = []string{
+	"acorn", "anchor", "apple", "arrow", "aspen", "badger", "bagel", "banjo",
+	"barn", "basil", "beacon", "beaver", "bell", "berry", "bison", "blossom",
+	"boat", "bonsai", "boulder", "breeze", "brook", "bubble", "buffalo",
+	"bunny", "cabin", "cactus", "camel", "candle", "canoe", "canyon", "carrot",
+	"castle", "cedar", "cello", "cherry", "cloud", "clover", "comet", "compass",
+	"coral", "cricket", "crow", "cupcake", "daisy", "deer", "desert", "dolphin",
+	"donut", "dragon", "drum", "eagle", "echo", "ember", "falcon", "feather",
+	"fern", "fiddle", "finch", "fjord", "flame", "flute", "forest", "fox",
+	"galaxy", "garden", "gecko", "geyser", "ginger", "glacier", "goose",
+	"granite", "grape", "harbor", "hawk", "hazel", "hedge", "heron", "hill",
+	"honey", "horizon", "island", "ivy", "jackal", "jade", "jaguar", "jasmine",
+	"kayak", "kettle", "kite", "koala", "lagoon", "lamp", "lantern", "lark",
+	"lemon", "lily", "lion", "llama", "lotus", "lynx", "mango", "maple",
+	"marble", "meadow", "melon", "meteor", "mint", "moose", "moss", "mountain",
+	"nectar", "nest", "nova", "oak", "ocean", "olive", "orbit", "orchid",
+	"otter", "owl", "panda", "panther", "parrot", "peach", "pebble", "pelican",
+	"pepper", "piano", "pine", "planet", "plum", "pond", "poppy", "puffin",
+	"quartz", "quill", "rabbit", "raven", "reef", "river", "robin", "rocket",
+	"sage", "salmon", "sapphire", "sequoia", "shadow", "shell", "sparrow",
+	"spruce", "squirrel", "star", "stone", "storm", "summit", "swan", "thistle",
+	"thunder", "tiger", "tulip", "tundra", "turtle", "valley", "violet",
+	"volcano", "walnut", "walrus", "willow", "wolf", "wren", "yak", "zebra",
+}
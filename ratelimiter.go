@@ -11,12 +11,17 @@ import (
 	sredis "github.com/ulule/limiter/drivers/store/redis"
 )
 
-func ipRateLimiter(client *redis.Client) func(http.Handler) http.Handler {
-	rate := limiter.Rate{
-		Limit:  20,
-		Period: time.Hour,
-	}
+// pasteRate is how many pastes each address may create
+var pasteRate = limiter.Rate{
+	Limit:  20,
+	Period: time.Hour,
+}
 
+func ipRateLimiter(store limiter.Store, options ...stdlib.Option) func(http.Handler) http.Handler {
+	return stdlib.NewMiddleware(limiter.New(store, pasteRate), options...).Handler
+}
+
+func newRedisLimiterStore(client *redis.Client) limiter.Store {
 	store, err := sredis.NewStoreWithOptions(client, limiter.StoreOptions{
 		Prefix:   "ratelimiter",
 		MaxRetry: 3,
@@ -24,6 +29,5 @@ func ipRateLimiter(client *redis.Client) func(http.Handler) http.Handler {
 	if err != nil {
 		log.WithError(err).Fatal("failed to create redis limiter store")
 	}
-
-	return stdlib.NewMiddleware(limiter.New(store, rate)).Handler
+	return store
 }
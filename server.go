@@ -2,18 +2,18 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
-	"github.com/blockloop/icanhazpaste/rand"
 	"github.com/apex/log"
+	"github.com/blockloop/icanhazpaste/rand"
 	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
-	"github.com/go-redis/redis"
 	"github.com/pkg/errors"
 	"github.com/pressly/chi/render"
+	"github.com/ulule/limiter"
 )
 
 const (
@@ -23,6 +23,14 @@ const (
 	Gigabyte
 	Terabyte
 
+	// DefaultNameLength is the default number of characters in a paste name
+	DefaultNameLength = 20
+	// DefaultMaxSize is the default size of the largest paste which is accepted
+	DefaultMaxSize = 512 * Megabyte
+	// maxNameAttempts is the number of names tried before giving up on
+	// storing a paste when the names generated are already taken
+	maxNameAttempts = 5
+
 	helpText = `
  # send a file
  curl --data-binary @./notes.txt icanhazpaste.com
@@ -30,8 +38,78 @@ const (
  # send some raw text
  curl --data-binary 'Hello, there!' icanhazpaste.com
 
+ # upload a file of any type, keeping its name
+ curl -F f=@./screenshot.png icanhazpaste.com
+
  # send from stdin
  journalctl -xe -u dnsmasq | curl --data-binary @- icanhazpaste.com
+
+ # delete the paste after it has been read once
+ curl --data-binary 'hunter2' 'icanhazpaste.com?burn=1'
+
+ # browsers see pastes highlighted, pick the language with ?lang=
+ # or get the exact text that was pasted from /raw
+ curl icanhazpaste.com/raw/<name>
+
+ # see when a paste was created, its size, language and more
+ curl icanhazpaste.com/x/<name>/info
+
+ # delete a paste early with the token from the X-Delete-Token response header
+ curl -X DELETE -H 'X-Delete-Token: <token>' icanhazpaste.com/x/<name>
+
+ # protect a paste with a password
+ curl -H 'X-Paste-Password: hunter2' --data-binary @./notes.txt icanhazpaste.com
+
+ # read a protected paste with the header or basic auth
+ curl -H 'X-Paste-Password: hunter2' icanhazpaste.com/raw/<name>
+ curl -u :hunter2 icanhazpaste.com/raw/<name>
+
+ # make a paste which can be edited with the token from the X-Edit-Token header
+ curl -H 'X-Paste-Edit: 1' --data-binary @./notes.txt icanhazpaste.com
+
+ # add a revision to an editable paste, read old revisions or list them all
+ curl -X PUT -H 'X-Edit-Token: <token>' --data-binary @./notes.txt icanhazpaste.com/x/<name>
+ curl icanhazpaste.com/x/<name>/v/1
+ curl icanhazpaste.com/x/<name>/history
+
+ # copy a paste into a new editable paste which remembers where it came from
+ curl -X POST icanhazpaste.com/x/<name>/fork
+
+ # paste several files together and read them one at a time, or all at once
+ curl -F a=@./Dockerfile -F b=@./docker-compose.yml icanhazpaste.com
+ curl -H 'Content-Type: application/json' -H 'X-Paste-Format: bundle' -d '{"files": [{"name": "a.txt", "content": "hi"}]}' icanhazpaste.com
+ curl icanhazpaste.com/x/<name>/Dockerfile
+ curl -O icanhazpaste.com/x/<name>.tar.gz
+ curl -O icanhazpaste.com/x/<name>.zip
+
+ # compare two pastes, or two revisions of a paste
+ curl icanhazpaste.com/diff/<name>/<other>
+ curl icanhazpaste.com/diff/<name>@1/<name>@2
+
+ # paste with nc when the server is started with -tcp-addr
+ echo hello | nc icanhazpaste.com 9999
+
+ # or with ssh when the server is started with -ssh-addr
+ cat notes.txt | ssh -p 2222 icanhazpaste.com
+ ssh -p 2222 icanhazpaste.com get <name>
+
+ # or use the command line client, which is the server's own binary
+ icanhazpaste put ./notes.txt
+ make 2>&1 | icanhazpaste tee
+ icanhazpaste get <url>
+ icanhazpaste rm <url>
+
+ # use the JSON API, described at /api/v1/openapi.json
+ curl -H 'Content-Type: application/json' -d '{"content": "hi", "ttl": "1h"}' icanhazpaste.com/api/v1/pastes
+ # list the pastes made with the owner_token it responds with
+ curl -H 'X-Owner-Token: <owner_token>' icanhazpaste.com/api/v1/pastes
+
+ # choose the name of the paste
+ curl --data-binary @./notes.txt 'icanhazpaste.com?name=my-notes'
+
+ # choose when the paste expires (s, m, h, d or w)
+ curl --data-binary @./notes.txt 'icanhazpaste.com?ttl=10m'
+ curl -H 'X-Paste-TTL: 7d' --data-binary @./notes.txt icanhazpaste.com
 `
 )
 
@@ -40,33 +118,127 @@ var (
 	ErrNotFound = fmt.Errorf("paste not found")
 	// ErrInternal is an internal error
 	ErrInternal = fmt.Errorf("internal error")
+	// ErrExists is an error indicating a paste name is already taken
+	ErrExists = fmt.Errorf("paste already exists")
+	// ErrInvalidName is an error indicating a requested paste name is not allowed
+	ErrInvalidName = fmt.Errorf("paste names must be 3 to 64 letters, digits, '-' or '_'")
+	// ErrForbidden is an error indicating a delete token was missing or wrong
+	ErrForbidden = fmt.Errorf("invalid delete token")
+	// ErrUnauthorized is an error indicating the password of a protected paste
+	// was missing or wrong
+	ErrUnauthorized = fmt.Errorf("this paste is protected by a password")
+	// ErrTooLarge is an error indicating a paste is larger than is accepted
+	ErrTooLarge = fmt.Errorf("paste is too large")
+	// ErrEditForbidden is an error indicating an edit token was missing or
+	// wrong, or the paste cannot be edited
+	ErrEditForbidden = fmt.Errorf("invalid edit token")
+	// ErrBurnEdit is an error indicating a paste was asked to both burn and be
+	// editable
+	ErrBurnEdit = fmt.Errorf("pastes which burn cannot be edited")
+	// ErrBurnFork is an error indicating a paste which burns was asked to be
+	// forked, which would burn it
+	ErrBurnFork = fmt.Errorf("pastes which burn cannot be forked")
+	// ErrEmpty is an error indicating nothing was pasted
+	ErrEmpty = fmt.Errorf("paste is empty")
 )
 
 // Handler is an HTTP handler
 type Handler struct {
-	redis *redis.Client
-	store *Store
+	store   Store
+	limiter limiter.Store
+	// attempts limits how many wrong passwords are given for pastes
+	attempts *limiter.Limiter
+	names    rand.Generator
+	ipKey    []byte
+	maxSize  int64
+
+	defaultTTL time.Duration
+	minTTL     time.Duration
+	maxTTL     time.Duration
 }
 
-// NewHandler constructs a new handler with the given client
-func NewHandler(redisClient *redis.Client) *Handler {
+// NewHandler constructs a new handler which persists pastes to store and
+// tracks rate limits with limiterStore
+func NewHandler(store Store, limiterStore limiter.Store) *Handler {
+	names, _ := rand.NewCharset(rand.Alphanumeric, DefaultNameLength)
 	return &Handler{
-		redis: redisClient,
-		store: NewStore(redisClient),
+		store:      store,
+		limiter:    limiterStore,
+		attempts:   limiter.New(limiterStore, passwordRate),
+		names:      names,
+		ipKey:      newIPKey(),
+		maxSize:    DefaultMaxSize,
+		defaultTTL: DefaultFileTTL,
+		minTTL:     DefaultMinTTL,
+		maxTTL:     DefaultMaxTTL,
 	}
 }
 
+// SetNameGenerator sets the generator used to name new pastes
+func (h *Handler) SetNameGenerator(g rand.Generator) {
+	h.names = g
+}
+
+// SetIPHashKey sets the secret key used to hash the addresses of people who
+// create pastes. Without a fixed key the hashes change when the process restarts
+func (h *Handler) SetIPHashKey(key string) {
+	h.ipKey = []byte(key)
+}
+
+// SetMaxSize sets the size in bytes of the largest paste which is accepted
+func (h *Handler) SetMaxSize(max int64) {
+	h.maxSize = max
+}
+
+// SetTTLLimits sets the TTL given to pastes which do not choose their own and
+// the bounds of the TTLs they are allowed to choose
+func (h *Handler) SetTTLLimits(def, min, max time.Duration) error {
+	if min.Nanoseconds() <= 0 {
+		return errors.New("min ttl must be greater than zero")
+	}
+	if max < min {
+		return errors.New("max ttl must not be less than min ttl")
+	}
+	if def < min || def > max {
+		return errors.New("default ttl must be between min and max ttl")
+	}
+	h.defaultTTL, h.minTTL, h.maxTTL = def, min, max
+	return nil
+}
+
 // RegisterRoutes registers the HTTP routes with the given router
 func (h *Handler) RegisterRoutes(mux chi.Router) {
-	mux.With(
-		middleware.AllowContentType("application/x-www-form-urlencoded", "text/plain"),
-		ipRateLimiter(h.redis),
-	).Post("/", h.postForm)
+	mux.With(ipRateLimiter(h.limiter)).Post("/", h.postForm)
+	mux.With(ipRateLimiter(h.limiter)).Put("/x/{name}", h.editPaste)
+	mux.With(ipRateLimiter(h.limiter)).Post("/x/{name}/fork", h.forkPaste)
+
+	h.registerAPIRoutes(mux)
 
 	mux.Get("/styles.css", h.getStyles)
 	mux.Get("/", h.getForm)
 	mux.Get("/help", h.getHelp)
 	mux.Get("/x/{name}", h.getPaste)
+	mux.Get("/x/{name}.tar.gz", h.getBundleTarGz)
+	mux.Post("/x/{name}.tar.gz", h.getBundleTarGz)
+	mux.Get("/x/{name}.zip", h.getBundleZip)
+	mux.Post("/x/{name}.zip", h.getBundleZip)
+	mux.Post("/x/{name}", h.getPaste)
+	mux.Delete("/x/{name}", h.deletePaste)
+	mux.Get("/x/{name}/info", h.getInfo)
+	mux.Get("/x/{name}/history", h.getHistory)
+	mux.Post("/x/{name}/history", h.getHistory)
+	mux.Get("/x/{name}/v/{rev}", h.getPaste)
+	mux.Post("/x/{name}/v/{rev}", h.getPaste)
+	mux.Get("/x/{name}/v/{rev}/{file}", h.getBundleFile)
+	mux.Post("/x/{name}/v/{rev}/{file}", h.getBundleFile)
+	mux.Get("/x/{name}/{file}", h.getBundleFile)
+	mux.Post("/x/{name}/{file}", h.getBundleFile)
+	mux.Get("/diff/{a}/{b}", h.getDiff)
+	mux.Post("/diff/{a}/{b}", h.getDiff)
+	mux.Get("/raw/{name}", h.getRaw)
+	mux.Post("/raw/{name}", h.getRaw)
+	mux.Get("/raw/{name}/v/{rev}", h.getRaw)
+	mux.Post("/raw/{name}/v/{rev}", h.getRaw)
 }
 
 func (h *Handler) getStyles(w http.ResponseWriter, r *http.Request) {
@@ -89,22 +261,242 @@ func (h *Handler) getHelp(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// getPaste serves text pastes as a highlighted HTML page to browsers and
+// everything else as the exact bytes that were pasted
 func (h *Handler) getPaste(w http.ResponseWriter, r *http.Request) {
+	h.servePaste(w, r, render.GetAcceptedContentType(r) == render.ContentTypeHTML)
+}
+
+// getRaw serves a paste as the exact bytes that were pasted
+func (h *Handler) getRaw(w http.ResponseWriter, r *http.Request) {
+	h.servePaste(w, r, false)
+}
+
+// servePaste serves the latest revision of a paste, or the one named in the
+// URL, as HTML or its exact bytes. Pastes protected by a password are checked
+// before they are read so that wrong passwords do not burn them
+func (h *Handler) servePaste(w http.ResponseWriter, r *http.Request, html bool) {
 	name := chi.URLParam(r, "name")
-	text, ttl, err := h.store.Get(name)
+	rev, err := revisionParam(r)
+	if err != nil {
+		sendError(w, 404, ErrNotFound)
+		return
+	}
+	info, ok := h.authorize(w, r, name, html)
+	if !ok {
+		return
+	}
+	if rev > info.Revision {
+		sendError(w, 404, ErrNotFound)
+		return
+	}
+	if rev == info.Revision {
+		rev = 0
+	}
+	if html && info.Format == FormatBundle {
+		h.serveBundleHTML(w, r, name, rev)
+		return
+	}
+
+	var (
+		body    []byte
+		stream  ChunkStream
+		gzipped bool
+	)
+	gz, canGzip := h.store.(GzipStore)
+	switch {
+	case rev != 0:
+		stream, info, err = h.store.GetRevision(name, rev)
+	case info.Chunked:
+		stream, info, err = h.store.GetChunks(name)
+	case canGzip && !html && acceptsGzip(r):
+		body, gzipped, info, err = gz.GetGzip(name)
+	default:
+		body, info, err = h.store.Get(name)
+	}
+	if err == ErrNotFound {
+		sendError(w, 404, ErrNotFound)
+		return
+	}
 	if err != nil {
 		sendError(w, 500, err)
 		return
 	}
+	if stream != nil {
+		// pastes too large to render are shown to browsers as they were sent.
+		// pastes encrypted in the browser must be sent whole to be decrypted
+		if info.Chunked && !(html && info.Format == FormatEncrypted) {
+			streamPaste(w, stream, info)
+			return
+		}
+		if body, err = readChunks(stream); err != nil {
+			sendError(w, 500, err)
+			return
+		}
+	}
 
-	if len(text) == 0 {
+	w.Header().Set("Expires", info.Expires.Format(time.RFC1123))
+	w.Header().Set("X-Paste-Revision", strconv.Itoa(info.Revision))
+	if canGzip {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if gzipped {
+		// compressed pastes are served as they are stored rather than
+		// decompressed only for the client to compress them again
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+
+	if html && info.Format == FormatEncrypted {
+		renderDecryptorHTML(w, string(body), info)
+		return
+	}
+	if html && (info.ContentType == "" || isText(info.ContentType)) {
+		renderPasteHTML(w, r, string(body), info)
+		return
+	}
+	writeRaw(w, body, info)
+}
+
+// streamPaste serves the exact bytes of a paste one chunk at a time and closes
+// the stream
+func streamPaste(w http.ResponseWriter, stream ChunkStream, info *PasteInfo) {
+	defer stream.Close()
+
+	first, err := stream.Next()
+	if err != nil && err != io.EOF {
+		sendError(w, 500, err)
+		return
+	}
+	w.Header().Set("Expires", info.Expires.Format(time.RFC1123))
+	w.Header().Set("X-Paste-Revision", strconv.Itoa(info.Revision))
+	writeRaw(w, first, info)
+	if err := writeChunks(w, stream); err != nil {
+		// the response has started so all that can be done is to cut it short
+		log.WithError(err).WithField("name", info.Name).Error("failed to stream paste")
+	}
+}
+
+// revisionParam returns the revision named in the URL, or 0 for the latest
+func revisionParam(r *http.Request) (int, error) {
+	v := chi.URLParam(r, "rev")
+	if v == "" {
+		return 0, nil
+	}
+	rev, err := strconv.Atoi(v)
+	if err != nil || rev < 1 {
+		return 0, fmt.Errorf("invalid revision %q", v)
+	}
+	return rev, nil
+}
+
+// authorize checks the password of a protected paste, responding with a prompt
+// for browsers or 401 for everything else when it is missing or wrong. It
+// returns the paste's information and whether it may be served
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, name string, html bool) (*PasteInfo, bool) {
+	info, err := h.store.Stat(name)
+	if err == ErrNotFound {
+		sendError(w, 404, ErrNotFound)
+		return nil, false
+	}
+	if err != nil {
+		sendError(w, 500, err)
+		return nil, false
+	}
+	if info.PasswordHash == "" {
+		return info, true
+	}
+
+	password := requestPassword(r)
+	ok, err := h.verifyPassword(r.RemoteAddr, name, password, info.PasswordHash)
+	if err == ErrPasswordAttempts {
+		sendError(w, http.StatusTooManyRequests, err)
+		return nil, false
+	}
+	if err != nil {
+		sendError(w, 500, err)
+		return nil, false
+	}
+	if ok {
+		return info, true
+	}
+	if html {
+		renderPasswordHTML(w, info, password != "")
+		return nil, false
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="icanhazpaste"`)
+	sendError(w, http.StatusUnauthorized, ErrUnauthorized)
+	return nil, false
+}
+
+// getInfo serves the metadata of a paste as JSON
+func (h *Handler) getInfo(w http.ResponseWriter, r *http.Request) {
+	info, err := h.store.Stat(chi.URLParam(r, "name"))
+	if err == ErrNotFound {
 		sendError(w, 404, ErrNotFound)
 		return
 	}
+	if err != nil {
+		sendError(w, 500, err)
+		return
+	}
+	render.JSON(w, r, info)
+}
 
-	w.Header().Set("Expires", ttl.Format(time.RFC1123))
+// getHistory lists the revisions of a paste with when they were created, as
+// an HTML page for browsers and JSON for everything else
+func (h *Handler) getHistory(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	html := render.GetAcceptedContentType(r) == render.ContentTypeHTML
+	info, ok := h.authorize(w, r, name, html)
+	if !ok {
+		return
+	}
+	revs, err := h.store.Revisions(name)
+	if err == ErrNotFound {
+		sendError(w, 404, ErrNotFound)
+		return
+	}
+	if err != nil {
+		sendError(w, 500, err)
+		return
+	}
 
-	render.PlainText(w, r, text)
+	if html {
+		renderHistoryHTML(w, revs, info)
+		return
+	}
+	render.JSON(w, r, revs)
+}
+
+// deletePaste removes a paste before it expires. The delete token returned when
+// the paste was created must be given in the X-Delete-Token header or the
+// token query parameter
+func (h *Handler) deletePaste(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	info, err := h.store.Stat(name)
+	if err == ErrNotFound {
+		sendError(w, 404, ErrNotFound)
+		return
+	}
+	if err != nil {
+		sendError(w, 500, err)
+		return
+	}
+
+	token := r.Header.Get("X-Delete-Token")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if !checkToken(token, info.DeleteTokenHash) {
+		sendError(w, 403, ErrForbidden)
+		return
+	}
+
+	if err := h.store.Delete(name); err != nil && err != ErrNotFound {
+		sendError(w, 500, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
 // postForm handles all posts of pastes
@@ -113,43 +505,138 @@ func (h *Handler) getPaste(w http.ResponseWriter, r *http.Request) {
 // was to submit plain text. If the field 'clip' does not exist (specified in the
 // html form served from us) we will assume that the user was submitting a plaintext form
 func (h *Handler) postForm(w http.ResponseWriter, r *http.Request) {
-	if r.ContentLength > 1*Megabyte {
-		http.Error(w, "Request is too large. Must be < 1MB.", http.StatusRequestEntityTooLarge)
+	up, limited, ok := h.receive(w, r)
+	if !ok {
 		return
 	}
+	defer up.close()
 
-	rawBody, err := ioutil.ReadAll(r.Body)
+	p, status, err := h.createPaste(r, up, limited)
+	if err == ErrEmpty {
+		// nothing was submitted so just render the form again
+		h.getForm(w, r)
+		return
+	}
 	if err != nil {
-		sendError(w, 500, errors.Wrap(err, "failed to read body"))
+		sendError(w, status, err)
 		return
 	}
-	body := string(rawBody)
+	sendCreated(w, r, p.name, p.opts, p.token, p.editToken)
+}
 
-	if render.GetRequestContentType(r) == render.ContentTypeForm {
-		form, err := url.ParseQuery(body)
-		if err == nil {
-			if clip := form["clip"]; len(clip) > 0 {
-				body = clip[0]
-			}
+// createdPaste is a paste which has just been stored along with the tokens
+// which manage it
+type createdPaste struct {
+	name      string
+	opts      PasteOptions
+	size      int64
+	token     string
+	editToken string
+	// owner is the owner token the paste is listed under, if any
+	owner string
+}
+
+// info describes the paste as it was stored
+func (p *createdPaste) info() *PasteInfo {
+	now := time.Now().UTC()
+	return newPasteInfo(p.name, p.size, now, now.Add(p.opts.TTL), p.opts)
+}
+
+// createPaste stores an uploaded paste with the options chosen in its form, the
+// query string or the request headers. If it fails the status to respond with
+// is returned along with the error
+func (h *Handler) createPaste(r *http.Request, up *upload, limited *limitedBody) (*createdPaste, int, error) {
+	body, form := up.body, up.form
+
+	opts, err := h.pasteOptions(r, form)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if opts.Filename == "" {
+		opts.Filename = cleanFilename(up.filename)
+	}
+	if up.bundle {
+		if opts.Format != FormatPlain && opts.Format != FormatBundle {
+			return nil, http.StatusBadRequest, ErrBundleFormat
+		}
+		if opts.Burn {
+			return nil, http.StatusBadRequest, ErrBurnBundle
 		}
+		opts.Format = FormatBundle
+	} else if opts.Format == FormatBundle {
+		return nil, http.StatusBadRequest, ErrNotBundle
 	}
-
-	// nothing was submitted so just render the form again
 	if len(body) == 0 {
-		h.getForm(w, r)
-		return
+		return nil, http.StatusBadRequest, ErrEmpty
 	}
 
-	fname := rand.String(20)
-	if err := h.store.Put(fname, body); err != nil {
-		sendError(w, 500, err)
-		return
+	p := &createdPaste{size: int64(len(body))}
+	p.token, opts.DeleteTokenHash, err = newToken()
+	if err != nil {
+		return nil, 500, errors.Wrap(err, "failed to generate delete token")
+	}
+	if truthy(option(r, form, "edit", "X-Paste-Edit")) {
+		if opts.Burn {
+			return nil, http.StatusBadRequest, ErrBurnEdit
+		}
+		p.editToken, opts.EditTokenHash, err = newToken()
+		if err != nil {
+			return nil, 500, errors.Wrap(err, "failed to generate edit token")
+		}
+	}
+	h.describePaste(up.contentType, body, &opts)
+	p.owner = up.owner
+	if p.owner == "" {
+		p.owner = option(r, form, "owner_token", "X-Owner-Token")
+	}
+	opts.CreatorIP = hashIP(h.ipKey, r.RemoteAddr)
+	switch {
+	case up.creator != "":
+		opts.Creator = up.creator
+	case p.owner != "":
+		opts.Creator = ownerCreator(p.owner)
+	}
+
+	p.name, err = h.putNamed(r, form, h.putter(body, up.rest, opts, &p.size))
+	if limited.exceeded() {
+		return nil, http.StatusRequestEntityTooLarge, ErrTooLarge
 	}
-	uri := newURL(r, fname)
+	switch err {
+	case nil:
+	case ErrInvalidName:
+		return nil, http.StatusBadRequest, err
+	case ErrExists:
+		return nil, http.StatusConflict, err
+	default:
+		return nil, 500, err
+	}
+	p.opts = opts
+	return p, 0, nil
+}
+
+// sendCreated responds with the URL of a new paste and the tokens which manage
+// it, as a redirect for browsers, JSON, or plain text for everything else
+func sendCreated(w http.ResponseWriter, r *http.Request, name string, opts PasteOptions, token, editToken string) {
+	uri := newURL(r, name)
+	expires := time.Now().UTC().Add(opts.TTL)
 
 	data := map[string]interface{}{
-		"Name": fname,
-		"URL":  uri,
+		"Name":    name,
+		"URL":     uri,
+		"Burn":    opts.Burn,
+		"TTL":     int64(opts.TTL / time.Second),
+		"Expires": expires,
+
+		"DeleteToken": token,
+	}
+	if opts.Parent != "" {
+		data["Parent"] = opts.Parent
+	}
+	w.Header().Set("X-Paste-Expires", expires.Format(time.RFC1123))
+	w.Header().Set("X-Delete-Token", token)
+	if editToken != "" {
+		data["EditToken"] = editToken
+		w.Header().Set("X-Edit-Token", editToken)
 	}
 
 	switch render.GetAcceptedContentType(r) {
@@ -164,6 +651,245 @@ func (h *Handler) postForm(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// editPaste adds a revision to a paste. The edit token returned when the paste
+// was created must be given in the X-Edit-Token header or the token query
+// parameter. The body is read like the body of a new paste
+func (h *Handler) editPaste(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	info, err := h.store.Stat(name)
+	if err == ErrNotFound {
+		sendError(w, 404, ErrNotFound)
+		return
+	}
+	if err != nil {
+		sendError(w, 500, err)
+		return
+	}
+
+	token := r.Header.Get("X-Edit-Token")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if !checkToken(token, info.EditTokenHash) {
+		sendError(w, 403, ErrEditForbidden)
+		return
+	}
+
+	up, limited, ok := h.receive(w, r)
+	if !ok {
+		return
+	}
+	defer up.close()
+	if up.bundle != (info.Format == FormatBundle) {
+		sendError(w, http.StatusBadRequest, ErrBundleMismatch)
+		return
+	}
+
+	var src ChunkSource = newBytesChunks(up.body)
+	if up.rest != nil {
+		src = newReaderChunks(up.body, up.rest)
+	}
+	rev, err := h.store.Revise(name, src)
+	if limited.exceeded() {
+		sendError(w, http.StatusRequestEntityTooLarge, ErrTooLarge)
+		return
+	}
+	if err == ErrNotFound {
+		sendError(w, 404, ErrNotFound)
+		return
+	}
+	if err != nil {
+		sendError(w, 500, err)
+		return
+	}
+
+	uri := newURL(r, name+"/v/"+strconv.Itoa(rev))
+	data := map[string]interface{}{
+		"Name":     name,
+		"Revision": rev,
+		"URL":      uri,
+	}
+	w.Header().Set("X-Paste-Revision", strconv.Itoa(rev))
+
+	switch render.GetAcceptedContentType(r) {
+	case render.ContentTypeJSON:
+		render.JSON(w, r, &data)
+	default:
+		render.PlainText(w, r, uri)
+	}
+}
+
+// forkPaste copies the latest revision of a paste into a new editable paste
+// with a fresh TTL. The fork keeps the original's password, type and language
+// and records the name of the paste it was forked from
+func (h *Handler) forkPaste(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	info, ok := h.authorize(w, r, name, render.GetAcceptedContentType(r) == render.ContentTypeHTML)
+	if !ok {
+		return
+	}
+	if info.Burn {
+		sendError(w, http.StatusBadRequest, ErrBurnFork)
+		return
+	}
+	ttl, err := h.requestTTL(r, nil)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	token, tokenHash, err := newToken()
+	if err != nil {
+		sendError(w, 500, errors.Wrap(err, "failed to generate delete token"))
+		return
+	}
+	editToken, editTokenHash, err := newToken()
+	if err != nil {
+		sendError(w, 500, errors.Wrap(err, "failed to generate edit token"))
+		return
+	}
+	// the fork is listed with the pastes of the owner token the request gives
+	var creator string
+	if owner := option(r, nil, "owner_token", "X-Owner-Token"); owner != "" {
+		creator = ownerCreator(owner)
+	}
+	opts := PasteOptions{
+		TTL:             ttl,
+		DeleteTokenHash: tokenHash,
+		PasswordHash:    info.PasswordHash,
+		EditTokenHash:   editTokenHash,
+		ContentType:     info.ContentType,
+		Filename:        info.Filename,
+		Language:        info.Language,
+		Creator:         creator,
+		CreatorIP:       hashIP(h.ipKey, r.RemoteAddr),
+		Format:          info.Format,
+		Parent:          name,
+	}
+
+	stream, info, err := h.store.GetChunks(name)
+	if err == ErrNotFound {
+		sendError(w, 404, ErrNotFound)
+		return
+	}
+	if err != nil {
+		sendError(w, 500, err)
+		return
+	}
+
+	// pastes larger than a chunk are streamed from one name to the other
+	var put func(fork string) error
+	if info.Chunked {
+		defer stream.Close()
+		src := &storedChunks{ChunkStream: stream, size: info.Size}
+		put = func(fork string) error {
+			if src.started {
+				return errors.New("paste name was taken while the paste was stored")
+			}
+			return h.store.PutChunks(fork, src, opts)
+		}
+	} else {
+		body, err := readChunks(stream)
+		if err != nil {
+			sendError(w, 500, err)
+			return
+		}
+		put = func(fork string) error {
+			return h.store.Put(fork, body, opts)
+		}
+	}
+
+	fork, err := h.putNamed(r, nil, put)
+	switch err {
+	case nil:
+	case ErrInvalidName:
+		sendError(w, http.StatusBadRequest, err)
+		return
+	case ErrExists:
+		sendError(w, http.StatusConflict, err)
+		return
+	default:
+		sendError(w, 500, err)
+		return
+	}
+	sendCreated(w, r, fork, opts, token, editToken)
+}
+
+// receive reads the first chunk of a paste from the body of a request,
+// responding with an error if it is too large or cannot be read. The returned
+// body reports whether the rest of the paste turns out to be too large once it
+// is read
+func (h *Handler) receive(w http.ResponseWriter, r *http.Request) (*upload, *limitedBody, bool) {
+	if r.ContentLength > h.maxSize {
+		sendError(w, http.StatusRequestEntityTooLarge, ErrTooLarge)
+		return nil, nil, false
+	}
+	limited := newLimitedBody(r.Body, h.maxSize)
+	r.Body = limited
+
+	up, err := readUpload(r)
+	if limited.exceeded() {
+		sendError(w, http.StatusRequestEntityTooLarge, ErrTooLarge)
+		return nil, nil, false
+	}
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err)
+		return nil, nil, false
+	}
+	return up, limited, true
+}
+
+// putter returns a function which stores a paste under the name it is given.
+// Pastes larger than a chunk are streamed into the store from rest, after
+// which size is set to their size
+func (h *Handler) putter(body []byte, rest io.ReadCloser, opts PasteOptions, size *int64) func(name string) error {
+	if rest == nil {
+		return func(name string) error {
+			return h.store.Put(name, body, opts)
+		}
+	}
+	src := newReaderChunks(body, rest)
+	return func(name string) error {
+		if src.started() {
+			return errors.New("paste name was taken while the paste was stored")
+		}
+		err := h.store.PutChunks(name, src, opts)
+		*size = src.Size()
+		return err
+	}
+}
+
+// putNamed stores a paste with put under the name requested in the form, the
+// query string or the X-Paste-Name header, or under a newly generated name if
+// none was requested. It returns the name the paste was stored under
+func (h *Handler) putNamed(r *http.Request, form url.Values, put func(name string) error) (string, error) {
+	name := option(r, form, "name", "X-Paste-Name")
+	if name == "" {
+		return h.putPaste(put)
+	}
+	if !validSlug(name) {
+		return "", ErrInvalidName
+	}
+	return name, put(name)
+}
+
+// putPaste stores a paste with put under a newly generated name, trying
+// another name if the one generated is already taken
+func (h *Handler) putPaste(put func(name string) error) (string, error) {
+	for i := 0; i < maxNameAttempts; i++ {
+		name, err := h.names.String()
+		if err != nil {
+			return "", errors.Wrap(err, "failed to generate paste name")
+		}
+		err = put(name)
+		if err != ErrExists {
+			return name, err
+		}
+		log.WithField("name", name).Warn("generated paste name is taken")
+	}
+	return "", errors.New("failed to find a free paste name")
+}
+
 func sendError(w http.ResponseWriter, status int, err error) {
 	msg := err.Error()
 	w.WriteHeader(status)
@@ -177,8 +903,13 @@ func sendError(w http.ResponseWriter, status int, err error) {
 }
 
 func newURL(r *http.Request, name string) string {
+	return absoluteURL(r, "/x/"+name)
+}
+
+// absoluteURL returns the URL of path on the host the request was made to
+func absoluteURL(r *http.Request, path string) string {
 	u, _ := url.ParseRequestURI(r.RequestURI)
-	u.Scheme, u.Host, u.Path = "http", r.Host, "/x/"+name
+	u.Scheme, u.Host, u.Path, u.RawQuery = "http", r.Host, path, ""
 	if r.TLS != nil {
 		u.Scheme = "https"
 	}
@@ -0,0 +1,623 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ulule/limiter"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// sshHandshakeTimeout is how long a client has to authenticate
+	sshHandshakeTimeout = 30 * time.Second
+	// sshIdleTimeout is how long an SSH connection may go without sending or
+	// receiving anything before it is closed
+	sshIdleTimeout = 5 * time.Minute
+	// DefaultSSHTimeout is how long an SSH connection may last however busy it
+	// is, so slow connections cannot be held open forever
+	DefaultSSHTimeout = 10 * time.Minute
+	// DefaultSSHMaxConns is how many SSH connections are handled at once.
+	// Further connections wait to be accepted until one finishes
+	DefaultSSHMaxConns = 100
+
+	sshHelpText = `usage: ssh <host> [command]
+
+  put [flags]               paste stdin and print its URL (the default)
+  get [-password p] <name>  print the exact bytes of a paste
+  rm [-token t] <name>      delete a paste, without a token if it is yours
+  ls                        list your pastes
+  help                      print this help
+
+flags of put:
+  -name, -filename, -lang, -ttl, -burn, -edit, -password
+
+  cat notes.txt | ssh <host>
+  make 2>&1 | ssh <host> put -ttl 1h -filename build.log
+`
+)
+
+// ErrSSHUsage is an error indicating an SSH command was given the wrong
+// arguments
+var ErrSSHUsage = fmt.Errorf("unknown command or arguments, see help")
+
+// AuthorizedKeys maps the keys allowed to connect over SSH to the owners
+// their pastes are attributed to
+type AuthorizedKeys map[string]string
+
+// LoadAuthorizedKeys reads a file in the format of OpenSSH's authorized_keys.
+// The comment after each key names its owner, and keys without one are owned
+// by their fingerprint
+func LoadAuthorizedKeys(path string) (AuthorizedKeys, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(AuthorizedKeys)
+	for n, line := range strings.Split(string(raw), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, n+1, err)
+		}
+		owner := strings.TrimSpace(comment)
+		if owner == "" {
+			owner = ssh.FingerprintSHA256(key)
+		}
+		keys[string(key.Marshal())] = owner
+	}
+	return keys, nil
+}
+
+// LoadHostKey reads the private key the SSH server identifies itself with,
+// such as one written by ssh-keygen. An empty path generates a key which only
+// lasts until the process exits, so clients will see it change
+func LoadHostKey(path string) (ssh.Signer, error) {
+	if path == "" {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return ssh.NewSignerFromKey(key)
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(raw)
+}
+
+// SSHServer accepts pastes and commands over SSH, for machines which already
+// have keys to reach the server:
+//
+//	cat notes.txt | ssh icanhazpaste.com
+//	ssh icanhazpaste.com get <name>
+//
+// Anyone may connect unless authorized keys are set, in which case only their
+// owners may connect and their pastes are attributed to them rather than to
+// their address. Pastes share the rate limit and size limit of the handler's
+// HTTP routes
+type SSHServer struct {
+	handler    *Handler
+	limiter    *limiter.Limiter
+	baseURL    string
+	hostKey    ssh.Signer
+	authorized AuthorizedKeys
+	timeout    time.Duration
+	maxConns   int
+}
+
+// NewSSHServer creates an SSHServer which identifies itself with hostKey,
+// stores pastes with h and links to them at baseURL, such as
+// https://icanhazpaste.com
+func NewSSHServer(h *Handler, baseURL string, hostKey ssh.Signer) *SSHServer {
+	return &SSHServer{
+		handler:  h,
+		limiter:  limiter.New(h.limiter, pasteRate),
+		baseURL:  baseURL,
+		hostKey:  hostKey,
+		timeout:  DefaultSSHTimeout,
+		maxConns: DefaultSSHMaxConns,
+	}
+}
+
+// SetAuthorizedKeys sets the only keys which may connect and who owns them.
+// Without them anyone may connect
+func (s *SSHServer) SetAuthorizedKeys(keys AuthorizedKeys) {
+	s.authorized = keys
+}
+
+// SetTimeout sets how long a connection may last
+func (s *SSHServer) SetTimeout(d time.Duration) {
+	s.timeout = d
+}
+
+// SetMaxConns sets how many connections are handled at once
+func (s *SSHServer) SetMaxConns(n int) {
+	s.maxConns = n
+}
+
+// config returns the settings connections are accepted with
+func (s *SSHServer) config() *ssh.ServerConfig {
+	cfg := &ssh.ServerConfig{}
+	if s.authorized == nil {
+		cfg.NoClientAuth = true
+	} else {
+		cfg.PublicKeyCallback = func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			owner, ok := s.authorized[string(key.Marshal())]
+			if !ok {
+				return nil, errors.New("unknown public key")
+			}
+			return &ssh.Permissions{Extensions: map[string]string{"owner": owner}}, nil
+		}
+	}
+	cfg.AddHostKey(s.hostKey)
+	return cfg
+}
+
+// ListenAndServe listens on the TCP address addr and accepts SSH connections
+// from it
+func (s *SSHServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts SSH connections from l until it fails. No more than the
+// maximum number of connections are accepted at once
+func (s *SSHServer) Serve(l net.Listener) error {
+	defer l.Close()
+	cfg := s.config()
+	sem := make(chan struct{}, s.maxConns)
+	var delay time.Duration
+	for {
+		sem <- struct{}{}
+		conn, err := l.Accept()
+		if err != nil {
+			<-sem
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				log.WithError(err).Warnf("failed to accept SSH connection, retrying in %s", delay)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		go func() {
+			defer func() { <-sem }()
+			s.handle(conn, cfg)
+		}()
+	}
+}
+
+// handle authenticates an SSH connection and runs the commands of its sessions
+func (s *SSHServer) handle(conn net.Conn, cfg *ssh.ServerConfig) {
+	defer conn.Close()
+	addr := conn.RemoteAddr().String()
+
+	deadline := time.Now().Add(s.timeout)
+	timer := time.AfterFunc(sshHandshakeTimeout, func() { conn.Close() })
+	sc, chans, reqs, err := ssh.NewServerConn(&sshIdleConn{Conn: conn, timeout: sshIdleTimeout, deadline: deadline}, cfg)
+	timer.Stop()
+	if err != nil {
+		log.WithError(err).WithField("remote", addr).Debug("SSH handshake failed")
+		return
+	}
+	defer sc.Close()
+	go ssh.DiscardRequests(reqs)
+
+	c := &sshConn{server: s, addr: addr, deadline: deadline}
+	if sc.Permissions != nil {
+		c.owner = sc.Permissions.Extensions["owner"]
+	}
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			log.WithError(err).WithField("remote", addr).Warn("failed to accept SSH session")
+			continue
+		}
+		go c.session(ch, reqs)
+	}
+}
+
+// sshConn is an authenticated SSH connection
+type sshConn struct {
+	server *SSHServer
+	addr   string
+	// owner is who the key the connection authenticated with belongs to, or
+	// empty if anyone may connect
+	owner string
+	// deadline is when the connection is closed however busy it is
+	deadline time.Time
+}
+
+// creator is who pastes made over the connection are attributed to: the owner
+// of its key or else its address
+func (c *sshConn) creator() string {
+	key := c.server.handler.ipKey
+	if c.owner != "" {
+		return sshCreator(key, c.owner)
+	}
+	return hashIP(key, c.addr)
+}
+
+// rateKey returns the key the rate limit of the connection is tracked under.
+// Connections without an owner share the limit of their address
+func (c *sshConn) rateKey() string {
+	if c.owner != "" {
+		return "ssh:" + c.owner
+	}
+	return ipKey(c.addr)
+}
+
+// session runs the command of a session, or put if it has none, once the
+// client asks for it and then reports its exit status
+func (c *sshConn) session(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	var pty bool
+	for req := range reqs {
+		var args []string
+		switch req.Type {
+		case "pty-req":
+			pty = true
+			req.Reply(true, nil)
+			continue
+		case "env":
+			req.Reply(true, nil)
+			continue
+		case "shell":
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			args = strings.Fields(payload.Command)
+		default:
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+		go ssh.DiscardRequests(reqs)
+
+		if pty && len(args) == 0 {
+			// an interactive login has nothing to paste
+			args = []string{"help"}
+		}
+		status := c.run(args, ch, ch, ch.Stderr(), pty)
+		ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		return
+	}
+}
+
+// run runs a command with the session's input and output and returns its
+// exit status. If the client asked for a terminal the text written for people
+// ends its lines with \r\n, but pastes are always written exactly as they are
+func (c *sshConn) run(args []string, in io.Reader, out, errOut io.Writer, pty bool) uint32 {
+	name := "put"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	text := out
+	if pty {
+		text, errOut = crlfWriter{out}, crlfWriter{errOut}
+	}
+	var err error
+	switch name {
+	case "put":
+		err = c.put(args, in, out, errOut)
+	case "get":
+		err = c.get(args, out)
+	case "rm":
+		err = c.rm(args)
+	case "ls":
+		err = c.ls(args, text)
+	case "help":
+		fmt.Fprint(text, sshHelpText)
+	default:
+		err = ErrSSHUsage
+	}
+
+	switch err {
+	case nil:
+		return 0
+	case flag.ErrHelp:
+		fmt.Fprint(text, sshHelpText)
+		return 0
+	case ErrSSHUsage:
+		fmt.Fprintf(errOut, "%s\n\n%s", err, sshHelpText)
+		return 2
+	case ErrNotFound, ErrUnauthorized, ErrForbidden, ErrExists, ErrTooLarge,
+		ErrRateLimited, ErrEmpty, ErrInvalidName, ErrBurnEdit, ErrSlowPaste,
+		ErrPasswordAttempts:
+	default:
+		if _, ok := err.(*sshUsageError); !ok {
+			log.WithError(err).WithField("remote", c.addr).WithField("command", name).Error("SSH command failed")
+			if !debug {
+				err = ErrInternal
+			}
+		}
+	}
+	fmt.Fprintf(errOut, "%s\n", err)
+	return 1
+}
+
+// sshUsageError is an error in the arguments of a command which is shown to
+// the client as it is
+type sshUsageError struct {
+	err error
+}
+
+func (e *sshUsageError) Error() string {
+	return e.err.Error()
+}
+
+// newSSHFlagSet creates the flags of a command. Their errors are returned
+// rather than written out along with their usage, which is in the help
+func newSSHFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	return fs
+}
+
+// put stores a paste read from in and writes its URL to out and its tokens
+// to errOut. The options of the paste are read like those of a form post so
+// they are checked the same way
+func (c *sshConn) put(args []string, in io.Reader, out, errOut io.Writer) error {
+	fs := newSSHFlagSet("put")
+	form := url.Values{}
+	for _, field := range []string{"name", "filename", "lang", "ttl", "password"} {
+		fs.String(field, "", "")
+	}
+	for _, field := range []string{"burn", "edit"} {
+		fs.Bool(field, false, "")
+	}
+	if err := fs.Parse(args); err != nil {
+		return sshFlagError(err)
+	}
+	if fs.NArg() > 0 {
+		return ErrSSHUsage
+	}
+	fs.Visit(func(f *flag.Flag) {
+		form.Set(f.Name, f.Value.String())
+	})
+
+	// the limit is only used up by sessions which send a paste, so it is
+	// looked at without being counted until there is one
+	s, h := c.server, c.server.handler
+	rate, err := s.limiter.Peek(context.Background(), c.rateKey())
+	if err != nil {
+		return err
+	}
+	if rate.Reached {
+		return ErrRateLimited
+	}
+
+	limited := newLimitedBody(ioutil.NopCloser(&sshInput{r: in, deadline: c.deadline}), h.maxSize)
+	body, rest, err := readHead(limited)
+	if limited.exceeded() {
+		return ErrTooLarge
+	}
+	if err != nil {
+		return err
+	}
+	up := &upload{body: body, rest: rest, form: form, creator: c.creator()}
+	defer up.close()
+	if len(body) == 0 {
+		return ErrEmpty
+	}
+
+	rate, err = s.limiter.Get(context.Background(), c.rateKey())
+	if err != nil {
+		return err
+	}
+	if rate.Reached {
+		return ErrRateLimited
+	}
+
+	r := &http.Request{
+		Method:     http.MethodPost,
+		URL:        &url.URL{},
+		Header:     http.Header{},
+		RemoteAddr: c.addr,
+	}
+	p, status, err := h.createPaste(r, up, limited)
+	if err != nil {
+		if status == http.StatusBadRequest {
+			return &sshUsageError{err}
+		}
+		return err
+	}
+
+	log.WithField("remote", c.addr).WithField("owner", c.owner).
+		WithField("name", p.name).WithField("size", p.size).Info("stored SSH paste")
+	fmt.Fprintln(out, s.baseURL+"/x/"+p.name)
+	fmt.Fprintf(errOut, "delete token: %s\n", p.token)
+	if p.editToken != "" {
+		fmt.Fprintf(errOut, "edit token: %s\n", p.editToken)
+	}
+	return nil
+}
+
+// get writes the exact bytes of a paste to out. Pastes protected by a password
+// are checked before they are read so that wrong passwords do not burn them
+func (c *sshConn) get(args []string, out io.Writer) error {
+	fs := newSSHFlagSet("get")
+	password := fs.String("password", "", "")
+	if err := fs.Parse(args); err != nil {
+		return sshFlagError(err)
+	}
+	if fs.NArg() != 1 {
+		return ErrSSHUsage
+	}
+	name := fs.Arg(0)
+
+	h := c.server.handler
+	store := h.store
+	info, err := store.Stat(name)
+	if err != nil {
+		return err
+	}
+	if info.PasswordHash != "" {
+		ok, err := h.verifyPassword(c.addr, name, *password, info.PasswordHash)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrUnauthorized
+		}
+	}
+	if !info.Chunked {
+		body, _, err := store.Get(name)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(body)
+		return err
+	}
+	stream, _, err := store.GetChunks(name)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	return writeChunks(out, stream)
+}
+
+// rm deletes a paste with its delete token. Pastes made by the owner of the
+// connection's key may be deleted without one
+func (c *sshConn) rm(args []string) error {
+	fs := newSSHFlagSet("rm")
+	token := fs.String("token", "", "")
+	if err := fs.Parse(args); err != nil {
+		return sshFlagError(err)
+	}
+	if fs.NArg() != 1 {
+		return ErrSSHUsage
+	}
+	name := fs.Arg(0)
+
+	h := c.server.handler
+	info, err := h.store.Stat(name)
+	if err != nil {
+		return err
+	}
+	owned := c.owner != "" && info.Creator == c.creator()
+	if !owned && !checkToken(*token, info.DeleteTokenHash) {
+		return ErrForbidden
+	}
+	if err := h.store.Delete(name); err != nil && err != ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// ls writes the URLs of the pastes made by the connection's owner, or from its
+// address, newest first
+func (c *sshConn) ls(args []string, out io.Writer) error {
+	if len(args) > 0 {
+		return ErrSSHUsage
+	}
+	h := c.server.handler
+	infos, err := h.store.List(c.creator())
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	for _, info := range infos {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", c.server.baseURL+"/x/"+info.Name, info.Size, info.Expires.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+// sshFlagError returns the error of flags which failed to parse, which is shown
+// to the client as it is unless they asked for help
+func sshFlagError(err error) error {
+	if err == flag.ErrHelp {
+		return err
+	}
+	return &sshUsageError{err}
+}
+
+// crlfWriter ends lines with \r\n as a terminal expects when the client asked
+// for one
+type crlfWriter struct {
+	w io.Writer
+}
+
+func (w crlfWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, strings.Replace(string(p), "\n", "\r\n", -1)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// sshIdleConn closes connections which have not sent or received anything for
+// longer than timeout, or which are still open at their deadline
+type sshIdleConn struct {
+	net.Conn
+	timeout  time.Duration
+	deadline time.Time
+}
+
+func (c *sshIdleConn) Read(p []byte) (int, error) {
+	c.extend()
+	return c.Conn.Read(p)
+}
+
+func (c *sshIdleConn) Write(p []byte) (int, error) {
+	c.extend()
+	return c.Conn.Write(p)
+}
+
+// extend moves the deadline of the connection timeout from now, but never
+// past the deadline of the whole connection
+func (c *sshIdleConn) extend() {
+	d := time.Now().Add(c.timeout)
+	if d.After(c.deadline) {
+		d = c.deadline
+	}
+	c.Conn.SetDeadline(d)
+}
+
+// sshInput reads a paste from a session. Closing the connection at its
+// deadline ends the session's input as if the paste were complete, so input
+// which ends after the deadline fails instead of being stored cut short
+type sshInput struct {
+	r        io.Reader
+	deadline time.Time
+}
+
+func (in *sshInput) Read(p []byte) (int, error) {
+	n, err := in.r.Read(p)
+	if err == io.EOF && !time.Now().Before(in.deadline) {
+		err = ErrSlowPaste
+	}
+	return n, err
+}
@@ -1,65 +1,182 @@
 package main
 
 import (
+	"sort"
 	"time"
-
-	"github.com/go-redis/redis"
-	"github.com/pkg/errors"
 )
 
 // DefaultFileTTL is the default amount of time files are active
 const DefaultFileTTL = time.Hour * 72
 
-type Store struct {
-	client *redis.Client
-	ttl    time.Duration
+// ChunkSize is the most a paste is read into memory at once. Larger pastes are
+// stored and served in chunks of this size
+const ChunkSize = 1 * Megabyte
+
+const (
+	// FormatPlain pastes are stored exactly as they were sent
+	FormatPlain = ""
+	// FormatEncrypted pastes were encrypted in the browser before they were
+	// sent. The server only ever sees the ciphertext; the key is kept in the
+	// fragment of the paste's URL
+	FormatEncrypted = "encrypted"
+	// FormatBundle pastes hold several named files, stored together as a tar
+	// archive
+	FormatBundle = "bundle"
+)
+
+// Store is a backend which persists pastes until they expire
+type Store interface {
+	// Put stores body under name. The paste expires after opts.TTL, or the
+	// store's default TTL if it is zero. ErrExists is returned if a paste
+	// with the same name has not yet expired
+	Put(name string, body []byte, opts PasteOptions) error
+	// Get retrieves the latest revision of a paste and information about it.
+	// ErrNotFound is returned if it does not exist. Pastes created with Burn
+	// are deleted by the same call that retrieves them
+	Get(name string) (body []byte, info *PasteInfo, err error)
+	// Delete removes a paste and all of its revisions. ErrNotFound is
+	// returned if it does not exist
+	Delete(name string) error
+	// TTL returns the time remaining until a paste expires. ErrNotFound is
+	// returned if it does not exist
+	TTL(name string) (time.Duration, error)
+	// Stat returns information about a paste without retrieving its
+	// contents. ErrNotFound is returned if it does not exist
+	Stat(name string) (*PasteInfo, error)
+	// PutChunks stores a paste like Put but reads its body from src one
+	// chunk at a time so it is never held in memory whole
+	PutChunks(name string, src ChunkSource, opts PasteOptions) error
+	// GetChunks retrieves a paste like Get but streams its body one chunk at
+	// a time. Pastes stored with Put are streamed as a single chunk. Pastes
+	// created with Burn are deleted by the same call that opens the stream
+	GetChunks(name string) (ChunkStream, *PasteInfo, error)
+	// Revise stores the body read from src as a new revision of an existing
+	// paste which expires along with it, returning the number of the new
+	// revision. The paste as it was first stored is revision 1. ErrNotFound
+	// is returned if the paste does not exist
+	Revise(name string, src ChunkSource) (int, error)
+	// GetRevision retrieves a revision of a paste like GetChunks. ErrNotFound
+	// is returned if the paste or the revision does not exist
+	GetRevision(name string, rev int) (ChunkStream, *PasteInfo, error)
+	// Revisions returns information about every revision of a paste, oldest
+	// first. ErrNotFound is returned if it does not exist
+	Revisions(name string) ([]RevisionInfo, error)
+	// List returns information about the latest revision of every paste
+	// created by creator which has not expired, newest first
+	List(creator string) ([]*PasteInfo, error)
 }
 
-func NewStoreTTL(client *redis.Client, ttl time.Duration) *Store {
-	s := &Store{
-		client: client,
-	}
-	s.SetTTL(ttl)
-	return s
+// GzipStore is a Store which can retrieve pastes without decompressing them
+type GzipStore interface {
+	Store
+	// GetGzip retrieves a paste like Get, but returns its body gzip
+	// compressed if it is stored that way. gzipped reports whether it was
+	GetGzip(name string) (body []byte, gzipped bool, info *PasteInfo, err error)
 }
 
-func NewStore(client *redis.Client) *Store {
-	return NewStoreTTL(client, DefaultFileTTL)
+// PasteOptions are the settings chosen by the author of a paste and the details
+// recorded about it when it is created
+type PasteOptions struct {
+	// TTL is how long the paste is kept. Zero uses the store's default
+	TTL time.Duration
+	// Burn deletes the paste the first time it is read
+	Burn bool
+	// DeleteTokenHash is the hash of the secret which must be presented to
+	// delete the paste before it expires
+	DeleteTokenHash string
+	// PasswordHash is the slow hash of the password which must be presented
+	// to read the paste, if it has one
+	PasswordHash string
+	// EditTokenHash is the hash of the secret which must be presented to add
+	// revisions to the paste. Pastes without one cannot be edited
+	EditTokenHash string
+	// Parent is the name of the paste this one was forked from, if any
+	Parent string
+	// ContentType is the media type of the paste
+	ContentType string
+	// Filename is the name of the file which was pasted, if any
+	Filename string
+	// Language is the language the paste is highlighted as, if any
+	Language string
+	// Creator identifies who the paste is listed under, an owner or an address,
+	// without revealing them
+	Creator string
+	// CreatorIP is a keyed hash of the address the paste was created from
+	CreatorIP string
+	// Format is how the body is stored, either FormatPlain or FormatEncrypted
+	Format string
 }
 
-func (s *Store) SetTTL(dur time.Duration) {
-	if dur.Nanoseconds() <= 0 {
-		panic("ttl must be greater than zero")
+// ttl returns the TTL chosen for the paste, or def if none was chosen
+func (o PasteOptions) ttl(def time.Duration) time.Duration {
+	if o.TTL > 0 {
+		return o.TTL
 	}
-	s.ttl = dur
+	return def
 }
 
-func (s *Store) Put(name, text string) error {
-	st := s.client.Set(name, text, s.ttl)
-	return errors.Wrap(st.Err(), "failed to put item")
+// PasteInfo describes a stored paste
+type PasteInfo struct {
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	Created     time.Time `json:"created"`
+	Expires     time.Time `json:"expires"`
+	Burn        bool      `json:"burn"`
+	ContentType string    `json:"content_type,omitempty"`
+	Filename    string    `json:"filename,omitempty"`
+	Language    string    `json:"language,omitempty"`
+	Creator     string    `json:"creator,omitempty"`
+	CreatorIP   string    `json:"creator_ip,omitempty"`
+	Format      string    `json:"format,omitempty"`
+	Protected   bool      `json:"protected,omitempty"`
+	Editable    bool      `json:"editable,omitempty"`
+	Parent      string    `json:"parent,omitempty"`
+	// Revision is the number of the revision described. Size describes the
+	// same revision while Created is when the paste was first stored
+	Revision int `json:"revision"`
+
+	DeleteTokenHash string `json:"-"`
+	PasswordHash    string `json:"-"`
+	EditTokenHash   string `json:"-"`
+	// Chunked is true for pastes stored in more than one chunk
+	Chunked bool `json:"-"`
 }
 
-func (s *Store) Get(name string) (text string, expires time.Time, err error) {
-	tx := s.client.TxPipeline()
-	defer tx.Close()
+// RevisionInfo describes a revision of a paste
+type RevisionInfo struct {
+	Revision int       `json:"revision"`
+	Size     int64     `json:"size"`
+	Created  time.Time `json:"created"`
+}
 
-	body := tx.Get(name)
-	ttl := tx.TTL(name)
+// newPasteInfo describes a paste created with opts
+func newPasteInfo(name string, size int64, created, expires time.Time, opts PasteOptions) *PasteInfo {
+	return &PasteInfo{
+		Name:        name,
+		Size:        size,
+		Created:     created,
+		Expires:     expires,
+		Burn:        opts.Burn,
+		ContentType: opts.ContentType,
+		Filename:    opts.Filename,
+		Language:    opts.Language,
+		Creator:     opts.Creator,
+		CreatorIP:   opts.CreatorIP,
+		Format:      opts.Format,
+		Protected:   opts.PasswordHash != "",
+		Editable:    opts.EditTokenHash != "",
+		Parent:      opts.Parent,
+		Revision:    1,
 
-	_, err = tx.Exec()
-	if err == redis.Nil {
-		err = nil
-		return
-	}
-	if err != nil {
-		err = errors.Wrap(err, "bad response from redis")
-		return
+		DeleteTokenHash: opts.DeleteTokenHash,
+		PasswordHash:    opts.PasswordHash,
+		EditTokenHash:   opts.EditTokenHash,
 	}
+}
 
-	text = body.Val()
-
-	if ttl.Val().Nanoseconds() > 0 {
-		expires = time.Now().UTC().Add(ttl.Val())
-	}
-	return
+// sortNewest sorts pastes so the most recently created comes first
+func sortNewest(infos []*PasteInfo) {
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Created.After(infos[j].Created)
+	})
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultCompressMinSize is the default size pastes must be to be compressed
+	DefaultCompressMinSize = 1 * Kilobyte
+
+	// codecNone marks chunks which are stored as they were sent
+	codecNone byte = 0
+	// codecGzip marks chunks which are gzip compressed
+	codecGzip byte = 1
+)
+
+// compressMagic starts every chunk and is followed by a byte naming the codec
+// it was stored with. Only chunks stored before compression was added lack it
+var compressMagic = []byte("\x00z")
+
+// CompressedStore compresses the bodies of pastes before they are passed to
+// another Store. Chunks smaller than a minimum size, or which do not get any
+// smaller, are stored as they are. Every chunk starts with the codec it was
+// stored with so that none can be mistaken for another, whatever it holds
+type CompressedStore struct {
+	Store
+	minSize int
+}
+
+// NewCompressedStore creates a CompressedStore which compresses chunks of at
+// least minSize bytes and stores them in store. A minSize of 0 compresses
+// nothing
+func NewCompressedStore(store Store, minSize int) *CompressedStore {
+	return &CompressedStore{
+		Store:   store,
+		minSize: minSize,
+	}
+}
+
+// Put implements Store
+func (s *CompressedStore) Put(name string, body []byte, opts PasteOptions) error {
+	return s.PutChunks(name, newBytesChunks(body), opts)
+}
+
+// PutChunks implements Store. Each chunk is compressed by itself
+func (s *CompressedStore) PutChunks(name string, src ChunkSource, opts PasteOptions) error {
+	return s.Store.PutChunks(name, &mapChunks{src, s.compress}, opts)
+}
+
+// Revise implements Store
+func (s *CompressedStore) Revise(name string, src ChunkSource) (int, error) {
+	return s.Store.Revise(name, &mapChunks{src, s.compress})
+}
+
+func (s *CompressedStore) compress(chunk []byte) ([]byte, error) {
+	if s.minSize <= 0 || len(chunk) < s.minSize {
+		return frame(codecNone, chunk), nil
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(chunk)/2))
+	buf.Write(compressMagic)
+	buf.WriteByte(codecGzip)
+	zw := gzip.NewWriter(buf)
+	zw.Write(chunk)
+	if err := zw.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to compress paste")
+	}
+	if buf.Len() >= len(chunk)+len(compressMagic)+1 {
+		return frame(codecNone, chunk), nil
+	}
+	return buf.Bytes(), nil
+}
+
+// frame returns a chunk which starts with the codec its body is stored with
+func frame(codec byte, body []byte) []byte {
+	out := make([]byte, 0, len(compressMagic)+1+len(body))
+	out = append(out, compressMagic...)
+	out = append(out, codec)
+	return append(out, body...)
+}
+
+// Get implements Store
+func (s *CompressedStore) Get(name string) ([]byte, *PasteInfo, error) {
+	stream, info, err := s.GetChunks(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := readChunks(stream)
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, info, nil
+}
+
+// GetChunks implements Store
+func (s *CompressedStore) GetChunks(name string) (ChunkStream, *PasteInfo, error) {
+	return s.GetRevision(name, 0)
+}
+
+// GetRevision implements Store
+func (s *CompressedStore) GetRevision(name string, rev int) (ChunkStream, *PasteInfo, error) {
+	stream, info, err := s.Store.GetRevision(name, rev)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &mapStream{stream, decompress}, info, nil
+}
+
+// GetGzip implements GzipStore. Only pastes stored in a single chunk are
+// returned compressed
+func (s *CompressedStore) GetGzip(name string) ([]byte, bool, *PasteInfo, error) {
+	stream, info, err := s.Store.GetChunks(name)
+	if err != nil {
+		return nil, false, nil, err
+	}
+	if info.Chunked {
+		body, err := readChunks(&mapStream{stream, decompress})
+		return body, false, info, err
+	}
+
+	chunk, err := readChunks(stream)
+	if err != nil {
+		return nil, false, nil, err
+	}
+	codec, body := unframe(chunk)
+	return body, codec == codecGzip, info, nil
+}
+
+// unframe returns the codec a chunk was stored with and its body. Chunks
+// stored before every chunk was framed are returned whole as codecNone
+func unframe(chunk []byte) (byte, []byte) {
+	if !bytes.HasPrefix(chunk, compressMagic) || len(chunk) <= len(compressMagic) {
+		return codecNone, chunk
+	}
+	switch codec := chunk[len(compressMagic)]; codec {
+	case codecNone, codecGzip:
+		return codec, chunk[len(compressMagic)+1:]
+	}
+	return codecNone, chunk
+}
+
+// decompress returns a chunk as it was sent
+func decompress(chunk []byte) ([]byte, error) {
+	codec, body := unframe(chunk)
+	if codec == codecNone {
+		return body, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress paste")
+	}
+	plain, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress paste")
+	}
+	return plain, nil
+}
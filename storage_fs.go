@@ -0,0 +1,714 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+)
+
+// metaExt is the file extension of the sidecar file holding a paste's metadata
+const metaExt = ".meta"
+
+// tempPrefix starts the names of the temporary files pastes are written to
+// before they are moved into place
+const tempPrefix = ".tmp-"
+
+// staleFileAge is how long a file which belongs to no paste is left alone
+// before it is swept. Temporary files are written to as uploads arrive, so one
+// which has not changed for this long was left behind by a crash
+const staleFileAge = time.Hour
+
+// FileStore is a Store which writes pastes to a directory on local disk. Each
+// paste is kept in a file named after the paste alongside a sidecar metadata
+// file which records when it was created, when it expires and how to serve it.
+// Pastes stored in more than one chunk are written as a sequence of chunks,
+// each preceded by its length as a 4 byte big endian integer. Later revisions
+// of a paste are kept in files named after the paste and the revision number
+type FileStore struct {
+	dir string
+	ttl time.Duration
+
+	mu   sync.RWMutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+type fileMeta struct {
+	Size        int64     `json:"size"`
+	Created     time.Time `json:"created"`
+	Expires     time.Time `json:"expires"`
+	Burn        bool      `json:"burn,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Filename    string    `json:"filename,omitempty"`
+	Language    string    `json:"language,omitempty"`
+	Creator     string    `json:"creator,omitempty"`
+	CreatorIP   string    `json:"creator_ip,omitempty"`
+	Format      string    `json:"format,omitempty"`
+	Parent      string    `json:"parent,omitempty"`
+
+	DeleteTokenHash string `json:"delete_token,omitempty"`
+	PasswordHash    string `json:"password,omitempty"`
+	EditTokenHash   string `json:"edit_token,omitempty"`
+	Chunked         bool   `json:"chunked,omitempty"`
+
+	// Revisions describes the revisions after the first, oldest first
+	Revisions []fileRevision `json:"revisions,omitempty"`
+}
+
+type fileRevision struct {
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+	Chunked bool      `json:"chunked,omitempty"`
+}
+
+// revision describes the given revision of the paste, numbered from 1
+func (m *fileMeta) revision(rev int) fileRevision {
+	if rev == 1 {
+		return fileRevision{Size: m.Size, Created: m.Created, Chunked: m.Chunked}
+	}
+	return m.Revisions[rev-2]
+}
+
+// latest returns the number of the latest revision of the paste
+func (m *fileMeta) latest() int {
+	return len(m.Revisions) + 1
+}
+
+func (m *fileMeta) info(name string, rev int) *PasteInfo {
+	r := m.revision(rev)
+	info := newPasteInfo(name, r.Size, m.Created, m.Expires, PasteOptions{
+		Burn:            m.Burn,
+		DeleteTokenHash: m.DeleteTokenHash,
+		PasswordHash:    m.PasswordHash,
+		EditTokenHash:   m.EditTokenHash,
+		ContentType:     m.ContentType,
+		Filename:        m.Filename,
+		Language:        m.Language,
+		Creator:         m.Creator,
+		CreatorIP:       m.CreatorIP,
+		Format:          m.Format,
+		Parent:          m.Parent,
+	})
+	info.Revision = rev
+	info.Chunked = r.Chunked
+	return info
+}
+
+// NewFileStore creates a FileStore in dir which expires pastes after ttl unless
+// they choose their own. The directory is created if it does not exist
+func NewFileStore(dir string, ttl time.Duration) (*FileStore, error) {
+	if ttl.Nanoseconds() <= 0 {
+		panic("ttl must be greater than zero")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create data directory")
+	}
+	return &FileStore{
+		dir: dir,
+		ttl: ttl,
+	}, nil
+}
+
+// Put implements Store
+func (s *FileStore) Put(name string, body []byte, opts PasteOptions) error {
+	return s.PutChunks(name, newBytesChunks(body), opts)
+}
+
+// PutChunks implements Store. The body is written to a temporary file without
+// holding the lock and moved into place once the name is known to be free
+func (s *FileStore) PutChunks(name string, src ChunkSource, opts PasteOptions) error {
+	if !validFileName(name) {
+		return errors.Errorf("invalid paste name %q", name)
+	}
+	if err := s.checkFree(name); err != nil {
+		return err
+	}
+
+	tmp, chunked, err := s.writeChunks(src)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	now := time.Now().UTC()
+	meta, err := json.Marshal(fileMeta{
+		Size:        src.Size(),
+		Created:     now,
+		Expires:     now.Add(opts.ttl(s.ttl)),
+		Burn:        opts.Burn,
+		ContentType: opts.ContentType,
+		Filename:    opts.Filename,
+		Language:    opts.Language,
+		Creator:     opts.Creator,
+		CreatorIP:   opts.CreatorIP,
+		Format:      opts.Format,
+		Parent:      opts.Parent,
+
+		DeleteTokenHash: opts.DeleteTokenHash,
+		PasswordHash:    opts.PasswordHash,
+		EditTokenHash:   opts.EditTokenHash,
+		Chunked:         chunked,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode metadata")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.readMeta(name); err != ErrNotFound {
+		if err == nil {
+			err = ErrExists
+		}
+		return err
+	}
+	// an expired paste which has not been swept yet is removed along with its
+	// revisions, which the new paste would otherwise inherit
+	if old, err := s.loadMeta(name); err == nil {
+		if err := s.remove(name, old); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, s.path(name)); err != nil {
+		return errors.Wrap(err, "failed to put item")
+	}
+	if err := writeFileAtomic(s.metaPath(name), meta); err != nil {
+		return errors.Wrap(err, "failed to put item metadata")
+	}
+	return nil
+}
+
+// checkFree returns ErrExists if a paste with the given name has not expired
+func (s *FileStore) checkFree(name string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.readMeta(name)
+	switch err {
+	case nil:
+		return ErrExists
+	case ErrNotFound:
+		return nil
+	}
+	return err
+}
+
+// writeChunks writes the body read from src to a temporary file and returns
+// its path. Bodies of a single chunk are written as they are and bodies of
+// more are written as a sequence of chunks
+func (s *FileStore) writeChunks(src ChunkSource) (path string, chunked bool, err error) {
+	f, err := ioutil.TempFile(s.dir, tempPrefix)
+	if err != nil {
+		return "", false, errors.Wrap(err, "failed to create item")
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil && cerr != nil {
+			err = errors.Wrap(cerr, "failed to write item")
+		}
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	first, err := src.Next()
+	if err == io.EOF {
+		return f.Name(), false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	chunk, err := src.Next()
+	if err == io.EOF {
+		if _, err := f.Write(first); err != nil {
+			return "", false, errors.Wrap(err, "failed to write item")
+		}
+		return f.Name(), false, nil
+	}
+
+	w := bufio.NewWriter(f)
+	writeFrame(w, first)
+	for ; err == nil; chunk, err = src.Next() {
+		writeFrame(w, chunk)
+	}
+	if err != io.EOF {
+		return "", false, err
+	}
+	if err := w.Flush(); err != nil {
+		return "", false, errors.Wrap(err, "failed to write item")
+	}
+	return f.Name(), true, nil
+}
+
+// Get implements Store
+func (s *FileStore) Get(name string) ([]byte, *PasteInfo, error) {
+	stream, info, err := s.GetChunks(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := readChunks(stream)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Size == 0 {
+		info.Size = int64(len(body))
+	}
+	return body, info, nil
+}
+
+// GetChunks implements Store. Burned pastes are removed as soon as their file
+// is opened and streamed from the open file
+func (s *FileStore) GetChunks(name string) (ChunkStream, *PasteInfo, error) {
+	return s.GetRevision(name, 0)
+}
+
+// GetRevision implements Store. Revision 0 is the latest
+func (s *FileStore) GetRevision(name string, rev int) (ChunkStream, *PasteInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, err := s.readMeta(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rev == 0 {
+		rev = meta.latest()
+	}
+	if rev < 1 || rev > meta.latest() {
+		return nil, nil, ErrNotFound
+	}
+
+	f, err := os.Open(s.revisionPath(name, rev))
+	if os.IsNotExist(err) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read item")
+	}
+	if meta.Burn {
+		if err := s.remove(name, meta); err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+	}
+	stream := &fileChunks{f: f, r: bufio.NewReader(f), chunked: meta.revision(rev).Chunked}
+	return stream, meta.info(name, rev), nil
+}
+
+// Revise implements Store. Like PutChunks the body is written to a temporary
+// file before the lock is taken
+func (s *FileStore) Revise(name string, src ChunkSource) (int, error) {
+	if err := s.checkFree(name); err != ErrExists {
+		if err == nil {
+			err = ErrNotFound
+		}
+		return 0, err
+	}
+
+	tmp, chunked, err := s.writeChunks(src)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, err := s.readMeta(name)
+	if err != nil {
+		return 0, err
+	}
+	rev := meta.latest() + 1
+	meta.Revisions = append(meta.Revisions, fileRevision{
+		Size:    src.Size(),
+		Created: time.Now().UTC(),
+		Chunked: chunked,
+	})
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to encode metadata")
+	}
+
+	path := s.revisionPath(name, rev)
+	if err := os.Rename(tmp, path); err != nil {
+		return 0, errors.Wrap(err, "failed to put item")
+	}
+	if err := writeFileAtomic(s.metaPath(name), raw); err != nil {
+		os.Remove(path)
+		return 0, errors.Wrap(err, "failed to put item metadata")
+	}
+	return rev, nil
+}
+
+// Revisions implements Store
+func (s *FileStore) Revisions(name string) ([]RevisionInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	meta, err := s.readMeta(name)
+	if err != nil {
+		return nil, err
+	}
+	revs := make([]RevisionInfo, meta.latest())
+	for i := range revs {
+		r := meta.revision(i + 1)
+		revs[i] = RevisionInfo{Revision: i + 1, Size: r.Size, Created: r.Created}
+	}
+	return revs, nil
+}
+
+// fileChunks is a ChunkStream of the file of a paste
+type fileChunks struct {
+	f       *os.File
+	r       *bufio.Reader
+	chunked bool
+	done    bool
+}
+
+func (c *fileChunks) Next() ([]byte, error) {
+	if c.done {
+		return nil, io.EOF
+	}
+	if !c.chunked {
+		c.done = true
+		body, err := ioutil.ReadAll(c.r)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read item")
+		}
+		return body, nil
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err == io.EOF {
+		c.done = true
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to read item")
+	}
+	chunk := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(c.r, chunk); err != nil {
+		return nil, errors.Wrap(err, "failed to read item")
+	}
+	return chunk, nil
+}
+
+func (c *fileChunks) Close() error {
+	return c.f.Close()
+}
+
+// Delete implements Store
+func (s *FileStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, err := s.readMeta(name)
+	if err != nil {
+		return err
+	}
+	return s.remove(name, meta)
+}
+
+// TTL implements Store
+func (s *FileStore) TTL(name string) (time.Duration, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	meta, err := s.readMeta(name)
+	if err != nil {
+		return 0, err
+	}
+	return time.Until(meta.Expires), nil
+}
+
+// Stat implements Store
+func (s *FileStore) Stat(name string) (*PasteInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	meta, err := s.readMeta(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(s.revisionPath(name, meta.latest()))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stat item")
+	}
+	info := meta.info(name, meta.latest())
+	if info.Size == 0 {
+		info.Size = fi.Size()
+	}
+	return info, nil
+}
+
+// List implements Store. Every paste's metadata is read to find those created
+// by creator
+func (s *FileStore) List(creator string) ([]*PasteInfo, error) {
+	metas, err := filepath.Glob(filepath.Join(s.dir, "*"+metaExt))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list metadata files")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var infos []*PasteInfo
+	for _, m := range metas {
+		name := strings.TrimSuffix(filepath.Base(m), metaExt)
+		meta, err := s.readMeta(name)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if meta.Creator == creator {
+			infos = append(infos, meta.info(name, meta.latest()))
+		}
+	}
+	sortNewest(infos)
+	return infos, nil
+}
+
+// StartSweeper starts a background goroutine which deletes expired pastes
+// every interval until Close is called
+func (s *FileStore) StartSweeper(interval time.Duration) {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := s.Sweep(); err != nil {
+					log.WithError(err).Error("failed to sweep expired pastes")
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Sweep deletes all expired pastes, then any files left behind which belong
+// to no paste
+func (s *FileStore) Sweep() error {
+	metas, err := filepath.Glob(filepath.Join(s.dir, "*"+metaExt))
+	if err != nil {
+		return errors.Wrap(err, "failed to list metadata files")
+	}
+
+	now := time.Now()
+	for _, m := range metas {
+		name := strings.TrimSuffix(filepath.Base(m), metaExt)
+		if err := s.sweep(name, now); err != nil {
+			log.WithError(err).WithField("name", name).Warn("failed to sweep paste")
+		}
+	}
+	return s.sweepStale(now)
+}
+
+// sweepStale deletes temporary files and revision files which have not been
+// touched for staleFileAge and belong to no paste: those left by a crash while
+// a paste was written or removed, or revisions of an expired paste whose name
+// was taken again
+func (s *FileStore) sweepStale(now time.Time) error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to list data directory")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, fi := range files {
+		if fi.IsDir() || strings.HasSuffix(fi.Name(), metaExt) || now.Sub(fi.ModTime()) < staleFileAge {
+			continue
+		}
+		if !strings.HasPrefix(fi.Name(), tempPrefix) && s.owned(fi.Name()) {
+			continue
+		}
+		log.WithField("file", fi.Name()).Debug("sweeping stale file")
+		if err := os.Remove(filepath.Join(s.dir, fi.Name())); err != nil && !os.IsNotExist(err) {
+			log.WithError(err).WithField("file", fi.Name()).Warn("failed to sweep stale file")
+		}
+	}
+	return nil
+}
+
+// owned reports whether a file in the data directory holds a revision of a
+// paste which has metadata. Files which cannot be read as a paste's are kept.
+// s.mu must be held
+func (s *FileStore) owned(file string) bool {
+	name, rev := file, 1
+	if i := strings.LastIndex(file, "."); i > 0 {
+		n, err := strconv.Atoi(file[i+1:])
+		if err != nil || n < 2 {
+			return true
+		}
+		name, rev = file[:i], n
+	}
+	if !validFileName(name) {
+		return true
+	}
+	meta, err := s.loadMeta(name)
+	if err == ErrNotFound {
+		return false
+	}
+	return err != nil || rev <= meta.latest()
+}
+
+// sweep deletes the named paste if it expired before now
+func (s *FileStore) sweep(name string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, err := s.loadMeta(name)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if now.Before(meta.Expires) {
+		return nil
+	}
+	log.WithField("name", name).Debug("sweeping expired paste")
+	return s.remove(name, meta)
+}
+
+// Close stops the sweeper if it is running
+func (s *FileStore) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop = nil
+	}
+	return nil
+}
+
+// readMeta reads the metadata of a paste. ErrNotFound is returned if the paste
+// does not exist or has expired. s.mu must be held
+func (s *FileStore) readMeta(name string) (*fileMeta, error) {
+	meta, err := s.loadMeta(name)
+	if err != nil {
+		return nil, err
+	}
+	// expired pastes linger on disk until they are swept
+	if !time.Now().Before(meta.Expires) {
+		return nil, ErrNotFound
+	}
+	return meta, nil
+}
+
+// loadMeta reads the metadata file of a paste regardless of whether it has
+// expired. s.mu must be held
+func (s *FileStore) loadMeta(name string) (*fileMeta, error) {
+	if !validFileName(name) {
+		return nil, ErrNotFound
+	}
+	raw, err := ioutil.ReadFile(s.metaPath(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read item metadata")
+	}
+
+	var meta fileMeta
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return nil, errors.Wrap(err, "failed to decode item metadata")
+	}
+	return &meta, nil
+}
+
+// remove deletes every revision of a paste and its metadata. s.mu must be held
+func (s *FileStore) remove(name string, meta *fileMeta) error {
+	// remove the metadata first so a partially removed paste is never served
+	if err := os.Remove(s.metaPath(name)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to delete item metadata")
+	}
+	for rev := 1; rev <= meta.latest(); rev++ {
+		if err := os.Remove(s.revisionPath(name, rev)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to delete item")
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// revisionPath returns the path of the file holding a revision of a paste. The
+// first revision is kept in the file named after the paste
+func (s *FileStore) revisionPath(name string, rev int) string {
+	if rev == 1 {
+		return s.path(name)
+	}
+	return s.path(name) + "." + strconv.Itoa(rev)
+}
+
+func (s *FileStore) metaPath(name string) string {
+	return filepath.Join(s.dir, name+metaExt)
+}
+
+// validFileName reports whether name is safe to use as a file name within the
+// data directory
+func validFileName(name string) bool {
+	if name == "" || name[0] == '.' {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// writeFrame writes a chunk preceded by its length
+func writeFrame(w io.Writer, chunk []byte) error {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(chunk)))
+	if _, err := w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(chunk)
+	return err
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path so
+// readers never observe a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), tempPrefix)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// memoryPurgeInterval is how often expired pastes are purged from a MemoryStore
+const memoryPurgeInterval = time.Minute
+
+// MemoryStore is a Store which keeps pastes in process memory. Pastes are lost
+// when the process exits which makes it most useful for development and tests
+type MemoryStore struct {
+	mu        sync.RWMutex
+	pastes    map[string]memoryPaste
+	ttl       time.Duration
+	lastPurge time.Time
+}
+
+type memoryPaste struct {
+	// revisions holds every revision of the paste, oldest first
+	revisions []memoryRevision
+	expires   time.Time
+	opts      PasteOptions
+}
+
+type memoryRevision struct {
+	chunks  [][]byte
+	size    int64
+	created time.Time
+}
+
+// info describes the given revision of the paste, numbered from 1
+func (p memoryPaste) info(name string, rev int) *PasteInfo {
+	r := p.revisions[rev-1]
+	info := newPasteInfo(name, r.size, p.revisions[0].created, p.expires, p.opts)
+	info.Revision = rev
+	info.Chunked = len(r.chunks) > 1
+	return info
+}
+
+func (p memoryPaste) expired(now time.Time) bool {
+	return !now.Before(p.expires)
+}
+
+// NewMemoryStore creates a MemoryStore which expires pastes after ttl unless
+// they choose their own
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	if ttl.Nanoseconds() <= 0 {
+		panic("ttl must be greater than zero")
+	}
+	return &MemoryStore{
+		pastes:    make(map[string]memoryPaste),
+		ttl:       ttl,
+		lastPurge: time.Now(),
+	}
+}
+
+// Put implements Store
+func (s *MemoryStore) Put(name string, body []byte, opts PasteOptions) error {
+	return s.PutChunks(name, newBytesChunks(body), opts)
+}
+
+// PutChunks implements Store
+func (s *MemoryStore) PutChunks(name string, src ChunkSource, opts PasteOptions) error {
+	if _, ok := s.lookup(name); ok {
+		return ErrExists
+	}
+
+	chunks, err := copyChunks(src)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastPurge) > memoryPurgeInterval {
+		s.purge(now)
+	}
+	if p, ok := s.pastes[name]; ok && !p.expired(now) {
+		return ErrExists
+	}
+	s.pastes[name] = memoryPaste{
+		revisions: []memoryRevision{{
+			chunks:  chunks,
+			size:    src.Size(),
+			created: now,
+		}},
+		expires: now.Add(opts.ttl(s.ttl)),
+		opts:    opts,
+	}
+	return nil
+}
+
+// copyChunks reads every chunk of src into memory
+func copyChunks(src ChunkSource) ([][]byte, error) {
+	var chunks [][]byte
+	for {
+		chunk, err := src.Next()
+		if err == io.EOF {
+			return chunks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, append([]byte(nil), chunk...))
+	}
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(name string) ([]byte, *PasteInfo, error) {
+	stream, info, err := s.GetChunks(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := readChunks(stream)
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, info, nil
+}
+
+// GetChunks implements Store
+func (s *MemoryStore) GetChunks(name string) (ChunkStream, *PasteInfo, error) {
+	return s.GetRevision(name, 0)
+}
+
+// GetRevision implements Store. Revision 0 is the latest
+func (s *MemoryStore) GetRevision(name string, rev int) (ChunkStream, *PasteInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pastes[name]
+	if !ok || p.expired(time.Now()) {
+		return nil, nil, ErrNotFound
+	}
+	if rev == 0 {
+		rev = len(p.revisions)
+	}
+	if rev < 1 || rev > len(p.revisions) {
+		return nil, nil, ErrNotFound
+	}
+	if p.opts.Burn {
+		delete(s.pastes, name)
+	}
+	return &sliceChunks{chunks: p.revisions[rev-1].chunks}, p.info(name, rev), nil
+}
+
+// Revise implements Store
+func (s *MemoryStore) Revise(name string, src ChunkSource) (int, error) {
+	if _, ok := s.lookup(name); !ok {
+		return 0, ErrNotFound
+	}
+	chunks, err := copyChunks(src)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pastes[name]
+	if !ok || p.expired(time.Now()) {
+		return 0, ErrNotFound
+	}
+	p.revisions = append(p.revisions, memoryRevision{
+		chunks:  chunks,
+		size:    src.Size(),
+		created: time.Now().UTC(),
+	})
+	s.pastes[name] = p
+	return len(p.revisions), nil
+}
+
+// Revisions implements Store
+func (s *MemoryStore) Revisions(name string) ([]RevisionInfo, error) {
+	p, ok := s.lookup(name)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	revs := make([]RevisionInfo, len(p.revisions))
+	for i, r := range p.revisions {
+		revs[i] = RevisionInfo{Revision: i + 1, Size: r.size, Created: r.created}
+	}
+	return revs, nil
+}
+
+// List implements Store
+func (s *MemoryStore) List(creator string) ([]*PasteInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	var infos []*PasteInfo
+	for name, p := range s.pastes {
+		if p.opts.Creator == creator && !p.expired(now) {
+			infos = append(infos, p.info(name, len(p.revisions)))
+		}
+	}
+	sortNewest(infos)
+	return infos, nil
+}
+
+// Delete implements Store
+func (s *MemoryStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pastes[name]
+	if !ok || p.expired(time.Now()) {
+		return ErrNotFound
+	}
+	delete(s.pastes, name)
+	return nil
+}
+
+// TTL implements Store
+func (s *MemoryStore) TTL(name string) (time.Duration, error) {
+	p, ok := s.lookup(name)
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return time.Until(p.expires), nil
+}
+
+// Stat implements Store
+func (s *MemoryStore) Stat(name string) (*PasteInfo, error) {
+	p, ok := s.lookup(name)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return p.info(name, len(p.revisions)), nil
+}
+
+// lookup returns the paste with the given name if it exists and has not expired
+func (s *MemoryStore) lookup(name string) (memoryPaste, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.pastes[name]
+	if !ok || p.expired(time.Now()) {
+		return memoryPaste{}, false
+	}
+	return p, true
+}
+
+// purge removes all expired pastes. s.mu must be held
+func (s *MemoryStore) purge(now time.Time) {
+	for name, p := range s.pastes {
+		if p.expired(now) {
+			delete(s.pastes, name)
+		}
+	}
+	s.lastPurge = now
+}
@@ -0,0 +1,686 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// redisMaxRetries is the number of times an optimistic transaction is retried
+// when a watched key changes underneath it
+const redisMaxRetries = 3
+
+// RedisStore is a Store backed by redis. Pastes are stored in chunks and
+// chunks with the same contents share a single blob, a hash keyed by the
+// digest of the chunk which holds the chunk and the number of pastes which
+// refer to it. Each paste is marked by a key under its name and keeps its
+// options and the digests of its chunks in a hash alongside it. Later
+// revisions keep their digests, size and created time in the same hash in
+// fields suffixed with the revision number
+//
+// blobs expire with the longest lived paste which refers to them. Pastes which
+// are deleted or burned release their reference and the blob is deleted with
+// the last one. Pastes which expire do not, so a blob may outlive them until
+// its own TTL runs out
+//
+// the names of the pastes made by each creator are kept in a set which lives
+// as long as the longest lived of them. Names are removed from it when their
+// paste is deleted, or when List finds it has expired
+type RedisStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewRedisStoreTTL creates a RedisStore which expires pastes after ttl
+func NewRedisStoreTTL(client *redis.Client, ttl time.Duration) *RedisStore {
+	s := &RedisStore{
+		client: client,
+	}
+	s.SetTTL(ttl)
+	return s
+}
+
+// NewRedisStore creates a RedisStore which expires pastes after DefaultFileTTL
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return NewRedisStoreTTL(client, DefaultFileTTL)
+}
+
+// SetTTL sets the amount of time new pastes are kept when they do not
+// choose their own TTL
+func (s *RedisStore) SetTTL(dur time.Duration) {
+	if dur.Nanoseconds() <= 0 {
+		panic("ttl must be greater than zero")
+	}
+	s.ttl = dur
+}
+
+// Put implements Store
+func (s *RedisStore) Put(name string, body []byte, opts PasteOptions) error {
+	return s.PutChunks(name, newBytesChunks(body), opts)
+}
+
+// PutChunks implements Store. Each chunk is held in its blob as it is read and
+// the name is claimed once they all are, releasing them again if it was taken
+// in the meantime
+func (s *RedisStore) PutChunks(name string, src ChunkSource, opts PasteOptions) error {
+	ttl := opts.ttl(s.ttl)
+
+	n, err := s.client.Exists(name).Result()
+	if err != nil {
+		return errors.Wrap(err, "bad response from redis")
+	}
+	if n > 0 {
+		return ErrExists
+	}
+
+	var digests []string
+	for {
+		chunk, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			var digest string
+			digest, err = s.holdBlob(chunk, ttl)
+			digests = append(digests, digest)
+		}
+		if err != nil {
+			s.releaseBlobs(digests)
+			return err
+		}
+	}
+
+	// the paste is only written if its name is still free when the
+	// transaction executes, like SETNX but covering the metadata too
+	fields := redisMetaFields(src.Size(), time.Now().UTC(), opts)
+	fields["blob"] = strings.Join(digests, ",")
+	claim := func(tx *redis.Tx) error {
+		n, err := tx.Exists(name).Result()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return ErrExists
+		}
+		var indexTTL time.Duration
+		if opts.Creator != "" {
+			if indexTTL, err = tx.PTTL(creatorKey(opts.Creator)).Result(); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+			// the name only marks that the paste exists and when it expires
+			pipe.Set(name, "", ttl)
+			pipe.Del(metaKey(name))
+			pipe.HMSet(metaKey(name), fields)
+			pipe.Expire(metaKey(name), ttl)
+			if opts.Creator != "" {
+				pipe.SAdd(creatorKey(opts.Creator), name)
+				if indexTTL < ttl {
+					pipe.PExpire(creatorKey(opts.Creator), ttl)
+				}
+			}
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < redisMaxRetries; i++ {
+		err = s.client.Watch(claim, name, metaKey(name))
+		if err != redis.TxFailedErr {
+			break
+		}
+	}
+	if err != nil {
+		s.releaseBlobs(digests)
+	}
+	if err == ErrExists {
+		return err
+	}
+	return errors.Wrap(err, "failed to put item")
+}
+
+// Get implements Store
+func (s *RedisStore) Get(name string) ([]byte, *PasteInfo, error) {
+	stream, info, err := s.GetChunks(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := readChunks(stream)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Size == 0 {
+		info.Size = int64(len(body))
+	}
+	return body, info, nil
+}
+
+// GetChunks implements Store. The blobs of a burned paste are released once
+// the stream is closed
+func (s *RedisStore) GetChunks(name string) (ChunkStream, *PasteInfo, error) {
+	return s.GetRevision(name, 0)
+}
+
+// GetRevision implements Store. Revision 0 is the latest
+func (s *RedisStore) GetRevision(name string, rev int) (ChunkStream, *PasteInfo, error) {
+	var (
+		meta map[string]string
+		body *redis.StringCmd
+		ttl  *redis.DurationCmd
+	)
+
+	// the metadata is read before the transaction is queued so a burned paste
+	// can be deleted within it. if another reader gets there first the
+	// watched keys change and the transaction is retried, finding nothing
+	get := func(tx *redis.Tx) error {
+		var err error
+		meta, err = tx.HGetAll(metaKey(name)).Result()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+			// pastes stored before blobs were shared keep their body under
+			// their name
+			body = pipe.Get(name)
+			ttl = pipe.TTL(name)
+			if meta["burn"] == "1" {
+				pipe.Del(name, metaKey(name))
+			}
+			return nil
+		})
+		return err
+	}
+
+	var err error
+	for i := 0; i < redisMaxRetries; i++ {
+		err = s.client.Watch(get, name, metaKey(name))
+		if err != redis.TxFailedErr {
+			break
+		}
+	}
+	if err == redis.Nil {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "bad response from redis")
+	}
+
+	if rev == 0 {
+		rev = latestRevision(meta)
+	}
+	if rev < 1 || rev > latestRevision(meta) {
+		return nil, nil, ErrNotFound
+	}
+
+	info := redisPasteInfo(name, meta, ttl.Val(), rev)
+	stream := &redisChunks{
+		store:   s,
+		digests: revisionDigests(meta, rev),
+	}
+	if info.Burn {
+		stream.release = allDigests(meta)
+	}
+	if len(stream.digests) == 0 {
+		data, err := body.Bytes()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "bad response from redis")
+		}
+		return newBytesChunks(data), info, nil
+	}
+	return stream, info, nil
+}
+
+// redisChunks is a ChunkStream of the blobs of a paste
+type redisChunks struct {
+	store   *RedisStore
+	digests []string
+	next    int
+	// release holds the blobs of a burned paste which must be released once
+	// the stream is closed
+	release []string
+}
+
+func (c *redisChunks) Next() ([]byte, error) {
+	if c.next >= len(c.digests) {
+		return nil, io.EOF
+	}
+	chunk, err := c.store.client.HGet(blobKey(c.digests[c.next]), "body").Bytes()
+	if err == redis.Nil {
+		return nil, errors.New("paste chunk has expired")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "bad response from redis")
+	}
+	c.next++
+	return chunk, nil
+}
+
+func (c *redisChunks) Close() error {
+	if c.release != nil {
+		c.store.releaseBlobs(c.release)
+		c.release = nil
+	}
+	return nil
+}
+
+// Revise implements Store. The new revision's blobs live as long as the paste
+// and are only added to it if it still exists once they are all held
+func (s *RedisStore) Revise(name string, src ChunkSource) (int, error) {
+	ttl, err := s.client.PTTL(name).Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "bad response from redis")
+	}
+	// redis responds with -2 when the key does not exist
+	if ttl == -2*time.Millisecond {
+		return 0, ErrNotFound
+	}
+
+	var digests []string
+	for {
+		chunk, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			var digest string
+			digest, err = s.holdBlob(chunk, ttl)
+			digests = append(digests, digest)
+		}
+		if err != nil {
+			s.releaseBlobs(digests)
+			return 0, err
+		}
+	}
+
+	var rev int
+	add := func(tx *redis.Tx) error {
+		n, err := tx.Exists(name).Result()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNotFound
+		}
+		meta, err := tx.HGetAll(metaKey(name)).Result()
+		if err != nil {
+			return err
+		}
+		rev = latestRevision(meta) + 1
+
+		_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.HMSet(metaKey(name), map[string]interface{}{
+				"revision":                    rev,
+				revisionField("blob", rev):    strings.Join(digests, ","),
+				revisionField("size", rev):    src.Size(),
+				revisionField("created", rev): time.Now().UTC().Format(time.RFC3339Nano),
+			})
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < redisMaxRetries; i++ {
+		err = s.client.Watch(add, name, metaKey(name))
+		if err != redis.TxFailedErr {
+			break
+		}
+	}
+	if err != nil {
+		s.releaseBlobs(digests)
+	}
+	if err == ErrNotFound {
+		return 0, err
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to put item")
+	}
+	return rev, nil
+}
+
+// Revisions implements Store
+func (s *RedisStore) Revisions(name string) ([]RevisionInfo, error) {
+	tx := s.client.TxPipeline()
+	defer tx.Close()
+
+	exists := tx.Exists(name)
+	meta := tx.HGetAll(metaKey(name))
+
+	if _, err := tx.Exec(); err != nil {
+		return nil, errors.Wrap(err, "bad response from redis")
+	}
+	if exists.Val() == 0 {
+		return nil, ErrNotFound
+	}
+
+	fields := meta.Val()
+	revs := make([]RevisionInfo, latestRevision(fields))
+	for i := range revs {
+		size, created := revisionStat(fields, i+1)
+		revs[i] = RevisionInfo{Revision: i + 1, Size: size, Created: created}
+	}
+	return revs, nil
+}
+
+// List implements Store
+func (s *RedisStore) List(creator string) ([]*PasteInfo, error) {
+	names, err := s.client.SMembers(creatorKey(creator)).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "bad response from redis")
+	}
+
+	var infos []*PasteInfo
+	var expired []interface{}
+	for _, name := range names {
+		info, err := s.Stat(name)
+		if err == ErrNotFound {
+			expired = append(expired, name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	if len(expired) > 0 {
+		if err := s.client.SRem(creatorKey(creator), expired...).Err(); err != nil {
+			log.WithError(err).WithField("creator", creator).Warn("failed to remove expired pastes from index")
+		}
+	}
+	sortNewest(infos)
+	return infos, nil
+}
+
+// Delete implements Store
+func (s *RedisStore) Delete(name string) error {
+	var digests []string
+	del := func(tx *redis.Tx) error {
+		n, err := tx.Exists(name).Result()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNotFound
+		}
+		meta, err := tx.HGetAll(metaKey(name)).Result()
+		if err != nil {
+			return err
+		}
+		digests = allDigests(meta)
+
+		_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.Del(name, metaKey(name))
+			if meta["creator"] != "" {
+				pipe.SRem(creatorKey(meta["creator"]), name)
+			}
+			return nil
+		})
+		return err
+	}
+
+	var err error
+	for i := 0; i < redisMaxRetries; i++ {
+		err = s.client.Watch(del, name, metaKey(name))
+		if err != redis.TxFailedErr {
+			break
+		}
+	}
+	if err == ErrNotFound {
+		return err
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to delete item")
+	}
+	s.releaseBlobs(digests)
+	return nil
+}
+
+// TTL implements Store
+func (s *RedisStore) TTL(name string) (time.Duration, error) {
+	ttl, err := s.client.TTL(name).Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "bad response from redis")
+	}
+	// redis responds with -2 when the key does not exist
+	if ttl == -2*time.Second {
+		return 0, ErrNotFound
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+	return ttl, nil
+}
+
+// Stat implements Store
+func (s *RedisStore) Stat(name string) (*PasteInfo, error) {
+	tx := s.client.TxPipeline()
+	defer tx.Close()
+
+	size := tx.StrLen(name)
+	ttl := tx.TTL(name)
+	meta := tx.HGetAll(metaKey(name))
+
+	if _, err := tx.Exec(); err != nil && err != redis.Nil {
+		return nil, errors.Wrap(err, "bad response from redis")
+	}
+	if ttl.Val() == -2*time.Second {
+		return nil, ErrNotFound
+	}
+
+	info := redisPasteInfo(name, meta.Val(), ttl.Val(), latestRevision(meta.Val()))
+	if info.Size == 0 {
+		info.Size = size.Val()
+	}
+	return info, nil
+}
+
+// bodyDigest returns the digest which identifies the blob holding body
+func bodyDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// blobKey returns the key of the hash holding the body with the given digest
+func blobKey(digest string) string {
+	return "blob:" + digest
+}
+
+// creatorKey returns the key of the set holding the names of the pastes made
+// by creator
+func creatorKey(creator string) string {
+	return "creator:" + creator
+}
+
+// revisionField returns the name of the metadata field holding field for the
+// given revision of a paste
+func revisionField(field string, rev int) string {
+	if rev == 1 {
+		return field
+	}
+	return field + ":" + strconv.Itoa(rev)
+}
+
+// latestRevision returns the number of the latest revision of a paste
+func latestRevision(meta map[string]string) int {
+	rev, err := strconv.Atoi(meta["revision"])
+	if err != nil || rev < 1 {
+		return 1
+	}
+	return rev
+}
+
+// revisionStat returns the size and created time of a revision of a paste
+func revisionStat(meta map[string]string, rev int) (int64, time.Time) {
+	size, _ := strconv.ParseInt(meta[revisionField("size", rev)], 10, 64)
+	created, _ := time.Parse(time.RFC3339Nano, meta[revisionField("created", rev)])
+	return size, created
+}
+
+// revisionDigests returns the digests of the blobs holding the chunks of a
+// revision of a paste, in order
+func revisionDigests(meta map[string]string, rev int) []string {
+	blob := meta[revisionField("blob", rev)]
+	if blob == "" {
+		return nil
+	}
+	return strings.Split(blob, ",")
+}
+
+// allDigests returns the digests of the blobs of every revision of a paste
+func allDigests(meta map[string]string) []string {
+	var digests []string
+	for rev := 1; rev <= latestRevision(meta); rev++ {
+		digests = append(digests, revisionDigests(meta, rev)...)
+	}
+	return digests
+}
+
+// holdBlob stores a chunk in its blob, or adds a reference to the blob if it
+// already exists, and makes sure it lives for at least ttl
+func (s *RedisStore) holdBlob(chunk []byte, ttl time.Duration) (string, error) {
+	digest := bodyDigest(chunk)
+	blob := blobKey(digest)
+
+	hold := func(tx *redis.Tx) error {
+		blobTTL, err := tx.PTTL(blob).Result()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.HSetNX(blob, "body", chunk)
+			pipe.HIncrBy(blob, "refs", 1)
+			if blobTTL < ttl {
+				pipe.Expire(blob, ttl)
+			}
+			return nil
+		})
+		return err
+	}
+
+	var err error
+	for i := 0; i < redisMaxRetries; i++ {
+		err = s.client.Watch(hold, blob)
+		if err != redis.TxFailedErr {
+			break
+		}
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "failed to put item")
+	}
+	return digest, nil
+}
+
+// releaseBlobs releases a reference to each of the blobs with the given
+// digests, deleting those which lose their last one. Failures are only logged
+// as the blobs expire by themselves
+func (s *RedisStore) releaseBlobs(digests []string) {
+	counts := make(map[string]int64, len(digests))
+	for _, d := range digests {
+		counts[d]++
+	}
+
+	for digest, count := range counts {
+		blob := blobKey(digest)
+		release := func(tx *redis.Tx) error {
+			refs, err := tx.HGet(blob, "refs").Int64()
+			if err == redis.Nil {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+				if refs <= count {
+					pipe.Del(blob)
+				} else {
+					pipe.HIncrBy(blob, "refs", -count)
+				}
+				return nil
+			})
+			return err
+		}
+
+		var err error
+		for i := 0; i < redisMaxRetries; i++ {
+			err = s.client.Watch(release, blob)
+			if err != redis.TxFailedErr {
+				break
+			}
+		}
+		if err != nil {
+			log.WithError(err).WithField("blob", digest).Warn("failed to release blob")
+		}
+	}
+}
+
+// metaKey returns the key of the hash holding the metadata of a paste
+func metaKey(name string) string {
+	return name + ":meta"
+}
+
+// redisMetaFields returns the fields of the hash holding the metadata of a paste
+func redisMetaFields(size int64, created time.Time, opts PasteOptions) map[string]interface{} {
+	fields := map[string]interface{}{
+		"size":    size,
+		"created": created.Format(time.RFC3339Nano),
+	}
+	if opts.Burn {
+		fields["burn"] = "1"
+	}
+	for k, v := range map[string]string{
+		"delete_token": opts.DeleteTokenHash,
+		"password":     opts.PasswordHash,
+		"edit_token":   opts.EditTokenHash,
+		"content_type": opts.ContentType,
+		"filename":     opts.Filename,
+		"language":     opts.Language,
+		"creator":      opts.Creator,
+		"creator_ip":   opts.CreatorIP,
+		"format":       opts.Format,
+		"parent":       opts.Parent,
+	} {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// redisPasteInfo describes a revision of a paste from the fields of its
+// metadata hash. Pastes stored before metadata was recorded have no size or
+// created time
+func redisPasteInfo(name string, fields map[string]string, ttl time.Duration, rev int) *PasteInfo {
+	size, _ := revisionStat(fields, rev)
+	_, created := revisionStat(fields, 1)
+
+	var expires time.Time
+	if ttl.Nanoseconds() > 0 {
+		expires = time.Now().UTC().Add(ttl)
+	}
+
+	info := newPasteInfo(name, size, created, expires, PasteOptions{
+		Burn:            fields["burn"] == "1",
+		DeleteTokenHash: fields["delete_token"],
+		PasswordHash:    fields["password"],
+		EditTokenHash:   fields["edit_token"],
+		ContentType:     fields["content_type"],
+		Filename:        fields["filename"],
+		Language:        fields["language"],
+		Creator:         fields["creator"],
+		CreatorIP:       fields["creator_ip"],
+		Format:          fields["format"],
+		Parent:          fields["parent"],
+	})
+	info.Revision = rev
+	info.Chunked = len(revisionDigests(fields, rev)) > 1
+	return info
+}
@@ -0,0 +1,73 @@
+package main
+
+// SealedStore encrypts the bodies of pastes before they are passed to another
+// Store so that they are never written anywhere in plaintext. Each chunk is
+// sealed by itself. Metadata is stored as it is. Pastes stored before
+// encryption was enabled are still served as they were stored
+//
+// sealed chunks are never the same twice, so the RedisStore cannot share
+// blobs between pastes with the same contents once encryption is enabled
+type SealedStore struct {
+	Store
+	keys *Keyring
+}
+
+// NewSealedStore creates a SealedStore which seals pastes with keys and stores
+// them in store
+func NewSealedStore(store Store, keys *Keyring) *SealedStore {
+	return &SealedStore{
+		Store: store,
+		keys:  keys,
+	}
+}
+
+// Put implements Store
+func (s *SealedStore) Put(name string, body []byte, opts PasteOptions) error {
+	return s.PutChunks(name, newBytesChunks(body), opts)
+}
+
+// PutChunks implements Store
+func (s *SealedStore) PutChunks(name string, src ChunkSource, opts PasteOptions) error {
+	return s.Store.PutChunks(name, &mapChunks{src, s.sealer(name)}, opts)
+}
+
+// Revise implements Store
+func (s *SealedStore) Revise(name string, src ChunkSource) (int, error) {
+	return s.Store.Revise(name, &mapChunks{src, s.sealer(name)})
+}
+
+// sealer returns a function which seals the chunks of the named paste
+func (s *SealedStore) sealer(name string) func([]byte) ([]byte, error) {
+	return func(chunk []byte) ([]byte, error) {
+		return s.keys.seal(name, chunk)
+	}
+}
+
+// Get implements Store
+func (s *SealedStore) Get(name string) ([]byte, *PasteInfo, error) {
+	stream, info, err := s.GetChunks(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := readChunks(stream)
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, info, nil
+}
+
+// GetChunks implements Store
+func (s *SealedStore) GetChunks(name string) (ChunkStream, *PasteInfo, error) {
+	return s.GetRevision(name, 0)
+}
+
+// GetRevision implements Store
+func (s *SealedStore) GetRevision(name string, rev int) (ChunkStream, *PasteInfo, error) {
+	stream, info, err := s.Store.GetRevision(name, rev)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &mapStream{stream, func(chunk []byte) ([]byte, error) {
+		return s.keys.open(name, chunk)
+	}}, info, nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ulule/limiter"
+)
+
+// DefaultTCPIdleTimeout is how long a raw TCP paste waits for more data before
+// it is taken to be complete. nc does not always close its side of the
+// connection at the end of its input so this is usually how pastes end
+const DefaultTCPIdleTimeout = 3 * time.Second
+
+// DefaultTCPReadTimeout is how long a raw TCP paste may take to send however
+// much data it has, so slow connections cannot be held open forever
+const DefaultTCPReadTimeout = 10 * time.Minute
+
+// DefaultTCPMaxConns is how many raw TCP connections are handled at once.
+// Further connections wait to be accepted until one finishes
+const DefaultTCPMaxConns = 100
+
+// tcpWriteTimeout is how long writing the response to a raw TCP paste may take
+const tcpWriteTimeout = 10 * time.Second
+
+// ErrSlowPaste is an error indicating a raw TCP paste was not sent in time
+var ErrSlowPaste = fmt.Errorf("paste took too long to send")
+
+// TCPServer accepts pastes sent over plain TCP connections, for machines with
+// nc but no curl:
+//
+//	echo hello | nc icanhazpaste.com 9999
+//
+// Everything sent until the connection is closed or goes idle is stored as a
+// paste and its URL is written back. Pastes share the rate limit and size
+// limit of the handler's HTTP routes
+type TCPServer struct {
+	handler     *Handler
+	limiter     *limiter.Limiter
+	baseURL     string
+	idleTimeout time.Duration
+	readTimeout time.Duration
+	maxConns    int
+}
+
+// NewTCPServer creates a TCPServer which stores pastes with h and links to them
+// at baseURL, such as https://icanhazpaste.com
+func NewTCPServer(h *Handler, baseURL string) *TCPServer {
+	return &TCPServer{
+		handler:     h,
+		limiter:     limiter.New(h.limiter, pasteRate),
+		baseURL:     baseURL,
+		idleTimeout: DefaultTCPIdleTimeout,
+		readTimeout: DefaultTCPReadTimeout,
+		maxConns:    DefaultTCPMaxConns,
+	}
+}
+
+// SetIdleTimeout sets how long a paste waits for more data before it is taken
+// to be complete
+func (s *TCPServer) SetIdleTimeout(d time.Duration) {
+	s.idleTimeout = d
+}
+
+// SetReadTimeout sets how long a paste may take to send all of its data
+func (s *TCPServer) SetReadTimeout(d time.Duration) {
+	s.readTimeout = d
+}
+
+// SetMaxConns sets how many connections are handled at once
+func (s *TCPServer) SetMaxConns(n int) {
+	s.maxConns = n
+}
+
+// ListenAndServe listens on the TCP address addr and accepts pastes from it
+func (s *TCPServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts pastes from connections to l until it fails. No more than the
+// maximum number of connections are accepted at once
+func (s *TCPServer) Serve(l net.Listener) error {
+	defer l.Close()
+	sem := make(chan struct{}, s.maxConns)
+	var delay time.Duration
+	for {
+		sem <- struct{}{}
+		conn, err := l.Accept()
+		if err != nil {
+			<-sem
+			// back off from temporary errors such as running out of file
+			// descriptors like net/http does
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				log.WithError(err).Warnf("failed to accept TCP connection, retrying in %s", delay)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		go func() {
+			defer func() { <-sem }()
+			s.handle(conn)
+		}()
+	}
+}
+
+// handle stores the paste sent over conn and responds with its URL, or with an
+// error message
+func (s *TCPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	h := s.handler
+	addr := conn.RemoteAddr().String()
+	ll := log.WithField("remote", addr)
+
+	// the limit is only used up by connections which send a paste, so it is
+	// looked at without being counted until there is one
+	rate, err := s.limiter.Peek(context.Background(), ipKey(addr))
+	if err != nil {
+		ll.WithError(err).Error("failed to check rate limit")
+		s.refuse(conn, ErrInternal)
+		return
+	}
+	if rate.Reached {
+		s.refuse(conn, ErrRateLimited)
+		return
+	}
+
+	in := &idleReader{conn: conn, timeout: s.idleTimeout, deadline: time.Now().Add(s.readTimeout)}
+	limited := newLimitedBody(ioutil.NopCloser(in), h.maxSize)
+	body, rest, err := readHead(limited)
+	if limited.exceeded() {
+		s.refuse(conn, ErrTooLarge)
+		return
+	}
+	if in.expired {
+		s.refuse(conn, ErrSlowPaste)
+		return
+	}
+	if err != nil {
+		ll.WithError(err).Warn("failed to read TCP paste")
+		return
+	}
+	if len(body) == 0 {
+		s.refuse(conn, ErrEmpty)
+		return
+	}
+
+	rate, err = s.limiter.Get(context.Background(), ipKey(addr))
+	if err != nil {
+		ll.WithError(err).Error("failed to check rate limit")
+		s.refuse(conn, ErrInternal)
+		return
+	}
+	if rate.Reached {
+		s.refuse(conn, ErrRateLimited)
+		return
+	}
+
+	ipHash := hashIP(h.ipKey, addr)
+	opts := PasteOptions{TTL: h.defaultTTL, Creator: ipHash, CreatorIP: ipHash}
+	h.describePaste("", body, &opts)
+	size := int64(len(body))
+	name, err := h.putPaste(h.putter(body, rest, opts, &size))
+	if limited.exceeded() {
+		s.refuse(conn, ErrTooLarge)
+		return
+	}
+	if in.expired {
+		s.refuse(conn, ErrSlowPaste)
+		return
+	}
+	if err != nil {
+		ll.WithError(err).Error("failed to store TCP paste")
+		s.refuse(conn, ErrInternal)
+		return
+	}
+
+	ll.WithField("name", name).WithField("size", size).Info("stored TCP paste")
+	s.reply(conn, s.baseURL+"/x/"+name)
+}
+
+// reply writes a line in response to a paste, either its URL or an error
+func (s *TCPServer) reply(conn net.Conn, msg interface{}) {
+	conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+	fmt.Fprintf(conn, "%s\n", msg)
+}
+
+// refuse replies with err to a paste which is not stored. Anything still
+// being sent is read and thrown away first, for a while, as closing a
+// connection with unread data resets it and the reply would be lost
+func (s *TCPServer) refuse(conn net.Conn, err error) {
+	s.reply(conn, err)
+	if tc, ok := conn.(*net.TCPConn); ok {
+		tc.CloseWrite()
+	}
+	conn.SetReadDeadline(time.Now().Add(tcpWriteTimeout))
+	io.Copy(ioutil.Discard, conn)
+}
+
+// ipKey returns the key the rate limit of addr is tracked under, the same
+// key the HTTP routes use
+func ipKey(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return net.ParseIP(host).String()
+}
+
+// idleReader reads from a connection until it goes quiet for longer than
+// timeout, which is taken to be the end of its data. Reading fails once the
+// deadline passes however much is still being sent
+type idleReader struct {
+	conn     net.Conn
+	timeout  time.Duration
+	deadline time.Time
+	// expired is true once the deadline has passed
+	expired bool
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	idle := time.Now().Add(r.timeout)
+	if idle.After(r.deadline) {
+		idle = r.deadline
+	}
+	r.conn.SetReadDeadline(idle)
+	n, err := r.conn.Read(p)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		if !time.Now().Before(r.deadline) {
+			r.expired = true
+			return n, ErrSlowPaste
+		}
+		err = io.EOF
+	}
+	return n, err
+}
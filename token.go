@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
+)
+
+const (
+	// tokenBytes is the number of random bytes in a secret token
+	tokenBytes = 16
+	// ipHashBytes is the number of bytes of an address hash which are kept
+	ipHashBytes = 8
+)
+
+// newToken generates a random secret token and the hash which is stored in
+// its place
+func newToken() (token, hash string, err error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the hash of a secret token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkToken reports whether token matches the stored hash
+func checkToken(token, hash string) bool {
+	if token == "" || hash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(hash)) == 1
+}
+
+// newIPKey generates a random key for hashing addresses
+func newIPKey() []byte {
+	b := make([]byte, sha256.Size)
+	rand.Read(b)
+	return b
+}
+
+// hashIP returns a keyed hash of the host in addr which identifies it without
+// revealing it
+func hashIP(key []byte, addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return keyedHash(key, addr)
+}
+
+// sshCreator returns the creator of pastes made over SSH by the owner of an
+// authorized key. It is prefixed like ownerCreator, so no address or owner
+// token given over HTTP hashes to it
+func sshCreator(key []byte, owner string) string {
+	return "ssh:" + keyedHash(key, owner)
+}
+
+// keyedHash returns a keyed hash of s which identifies it without revealing it
+func keyedHash(key []byte, s string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil)[:ipHashBytes])
+}
+
+// ownerCreator returns the creator pastes made with an owner token are listed
+// under. It is prefixed so it cannot collide with the creator of pastes made
+// over SSH or by an address
+func ownerCreator(token string) string {
+	return "owner:" + hashToken(token)
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pressly/chi/render"
+)
+
+// upload is a paste read from the body of a request
+type upload struct {
+	// body is the whole paste, or its first chunk if it is larger than
+	// ChunkSize
+	body []byte
+	// rest is the remainder of a paste larger than ChunkSize which has not
+	// been read yet, or nil
+	rest io.ReadCloser
+	// form holds the fields submitted alongside the paste from an html or
+	// multipart form
+	form url.Values
+	// filename is the name of the uploaded file, if any
+	filename string
+	// contentType is the media type the client declared for the paste
+	contentType string
+	// bundle is true if the paste is a bundle of several files, in which case
+	// body and rest hold them as a tar archive
+	bundle bool
+	// owner is the owner token the paste is listed under. If it is empty the
+	// token is read from the form, query string or X-Owner-Token header
+	owner string
+	// creator is who the paste is attributed to when it is not made over
+	// HTTP, in place of the owner of a token
+	creator string
+}
+
+// readUpload reads a paste from the body of a request. Multipart forms upload
+// their file, a bundle if they have several, or the 'clip' field if there are
+// no files. Url encoded forms are only treated as forms if they contain the
+// 'clip' field and JSON is only a bundle if it was sent with the bundle format;
+// anything else is stored exactly as it was sent. Only the first chunk of the
+// paste is read
+func readUpload(r *http.Request) (*upload, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		return readMultipart(r)
+	}
+
+	body, rest, err := readHead(r.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read body")
+	}
+	up := &upload{
+		body:        body,
+		rest:        rest,
+		contentType: mediaType,
+	}
+
+	// curl sends form-urlencoded by default even when the intention was to
+	// submit plain text so the body is only a form if it has our field. forms
+	// larger than a chunk are not from our html form
+	if render.GetRequestContentType(r) == render.ContentTypeForm && rest == nil {
+		up.contentType = ""
+		values, err := url.ParseQuery(string(body))
+		if err == nil {
+			if clip := values["clip"]; len(clip) > 0 {
+				up.body = []byte(clip[0])
+				up.form = values
+			}
+		}
+	}
+	// JSON is only a bundle when asked for, so JSON documents which happen to
+	// have a files field are still pasted as they are. likewise bundles sent
+	// as JSON must fit in a chunk. larger ones can be sent as multipart forms
+	if mediaType == "application/json" && rest == nil && option(r, nil, "format", "X-Paste-Format") == FormatBundle {
+		if files := parseBundleJSON(body); files != nil {
+			up.contentType = ""
+			if err := up.setBundle(files); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return up, nil
+}
+
+func readMultipart(r *http.Request) (*upload, error) {
+	// files larger than a chunk are spooled to disk rather than held in
+	// memory
+	if err := r.ParseMultipartForm(ChunkSize); err != nil {
+		return nil, errors.Wrap(err, "failed to parse multipart form")
+	}
+	up := &upload{
+		form: url.Values(r.MultipartForm.Value),
+	}
+
+	fields := make([]string, 0, len(r.MultipartForm.File))
+	for field := range r.MultipartForm.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var headers []*multipart.FileHeader
+	for _, field := range fields {
+		headers = append(headers, r.MultipartForm.File[field]...)
+	}
+
+	switch len(headers) {
+	case 0:
+		up.body = []byte(up.form.Get("clip"))
+		return up, nil
+	case 1:
+		fh := headers[0]
+		f, err := fh.Open()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open uploaded file")
+		}
+		up.body, up.rest, err = readHead(f)
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrap(err, "failed to read uploaded file")
+		}
+		up.filename = fh.Filename
+		up.contentType, _, _ = mime.ParseMediaType(fh.Header.Get("Content-Type"))
+		return up, nil
+	}
+
+	files := make([]bundleFile, len(headers))
+	for i, fh := range headers {
+		files[i] = bundleFile{
+			name: cleanFilename(fh.Filename),
+			size: fh.Size,
+			open: func(fh *multipart.FileHeader) func() (io.ReadCloser, error) {
+				return func() (io.ReadCloser, error) { return fh.Open() }
+			}(fh),
+		}
+	}
+	if err := up.setBundle(files); err != nil {
+		return nil, err
+	}
+	return up, nil
+}
+
+// close closes the unread remainder of the paste, if any
+func (up *upload) close() error {
+	if up.rest == nil {
+		return nil
+	}
+	return up.rest.Close()
+}
+
+// readHead reads the first chunk of a body. If that is all there is rest is nil
+// and r is closed, otherwise rest is r. The chunk only grows as large as the
+// body so small pastes do not hold a whole chunk of memory
+func readHead(r io.ReadCloser) (head []byte, rest io.ReadCloser, err error) {
+	var buf bytes.Buffer
+	_, err = io.CopyN(&buf, r, ChunkSize)
+	switch err {
+	case nil:
+		return buf.Bytes(), r, nil
+	case io.EOF:
+		return buf.Bytes(), nil, r.Close()
+	}
+	return nil, nil, err
+}
+
+// limitedBody is a request body which fails with ErrTooLarge once more than a
+// maximum number of bytes are read from it
+type limitedBody struct {
+	io.ReadCloser
+	left int64
+}
+
+func newLimitedBody(body io.ReadCloser, max int64) *limitedBody {
+	return &limitedBody{ReadCloser: body, left: max + 1}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded() {
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	if b.exceeded() {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
+
+// exceeded reports whether more than the maximum has been read
+func (b *limitedBody) exceeded() bool {
+	return b.left <= 0
+}
+
+// detectContentType determines the media type of a paste from the type declared
+// by the client, the extension of its filename, or its contents, in that order
+func detectContentType(declared, filename string, body []byte) string {
+	switch declared {
+	case "", "application/octet-stream", "application/x-www-form-urlencoded":
+	default:
+		if !strings.HasPrefix(declared, "multipart/") {
+			return declared
+		}
+	}
+	if ext := filepath.Ext(filename); ext != "" {
+		if ct := mime.TypeByExtension(ext); ct != "" {
+			return ct
+		}
+	}
+	return http.DetectContentType(body)
+}
+
+// isText reports whether a media type describes text which can be highlighted
+func isText(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript",
+		"application/x-sh", "application/x-yaml", "application/toml":
+		return true
+	}
+	return false
+}
+
+// cleanFilename strips any directories from an uploaded filename
+func cleanFilename(name string) string {
+	name = path.Base(strings.Replace(name, `\`, "/", -1))
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+// writeRaw writes the exact bytes of a paste with the type it was uploaded as.
+// Pastes are sandboxed so uploaded html cannot run scripts on our origin
+func writeRaw(w http.ResponseWriter, body []byte, info *PasteInfo) {
+	setRawHeaders(w, body, info)
+	w.Write(body)
+}
+
+// setRawHeaders sets the headers for serving the exact bytes of a paste. The
+// type of pastes stored without one is detected from the start of the body
+func setRawHeaders(w http.ResponseWriter, body []byte, info *PasteInfo) {
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	if info.Filename != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{
+			"filename": info.Filename,
+		}))
+	}
+}
+
+// acceptsGzip reports whether the client accepts responses which are gzip
+// compressed
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(v, ";")
+		if strings.TrimSpace(params[0]) != "gzip" {
+			continue
+		}
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				q, err := strconv.ParseFloat(p[2:], 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
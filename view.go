@@ -0,0 +1,244 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/blockloop/icanhazpaste/diff"
+	"github.com/blockloop/icanhazpaste/highlight"
+	"github.com/go-chi/chi"
+)
+
+// pasteLine is a single highlighted line of a paste
+type pasteLine struct {
+	Number int
+	HTML   template.HTML
+}
+
+// renderPasteHTML renders a paste as an HTML page with numbered, highlighted
+// lines. The language is taken from the lang query parameter, the language
+// recorded when the paste was created, or detected from the text
+func renderPasteHTML(w http.ResponseWriter, r *http.Request, text string, info *PasteInfo) {
+	var lang *highlight.Language
+	switch {
+	case r.URL.Query().Get("lang") != "":
+		lang = highlight.Lookup(r.URL.Query().Get("lang"))
+	case info.Language != "":
+		lang = highlight.Lookup(info.Language)
+	default:
+		lang = highlight.Detect(text)
+	}
+
+	highlighted := highlight.Lines(lang, text)
+	lines := make([]pasteLine, len(highlighted))
+	for i, l := range highlighted {
+		lines[i] = pasteLine{Number: i + 1, HTML: l}
+	}
+
+	data := map[string]interface{}{
+		"Name":      info.Name,
+		"Lines":     lines,
+		"Language":  "",
+		"Languages": highlight.Names(),
+		"Expires":   info.Expires.Format(time.RFC1123),
+		"Raw":       "/raw/" + info.Name,
+		"Parent":    info.Parent,
+		"Revision":  0,
+	}
+	// editable pastes say which revision is shown and link to the others
+	if info.Editable {
+		data["Revision"] = info.Revision
+		if chi.URLParam(r, "rev") != "" {
+			data["Raw"] = "/raw/" + info.Name + "/v/" + strconv.Itoa(info.Revision)
+		}
+	}
+	if lang != nil {
+		data["Language"] = lang.Name
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := HTMLPasteTemplate.Execute(w, data); err != nil {
+		sendError(w, 500, err)
+	}
+}
+
+// renderDecryptorHTML renders a page which decrypts an encrypted paste in the
+// browser with the key from the fragment of its URL. The ciphertext is embedded
+// in the page rather than fetched so pastes which burn are only read once
+func renderDecryptorHTML(w http.ResponseWriter, ciphertext string, info *PasteInfo) {
+	data := map[string]interface{}{
+		"Name":       info.Name,
+		"Ciphertext": ciphertext,
+		"Expires":    info.Expires.Format(time.RFC1123),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	if err := HTMLDecryptTemplate.Execute(w, data); err != nil {
+		sendError(w, 500, err)
+	}
+}
+
+// renderHistoryHTML renders a page listing the revisions of a paste, newest
+// first
+func renderHistoryHTML(w http.ResponseWriter, revs []RevisionInfo, info *PasteInfo) {
+	newest := make([]RevisionInfo, len(revs))
+	for i, rev := range revs {
+		newest[len(revs)-1-i] = rev
+	}
+	data := map[string]interface{}{
+		"Name":      info.Name,
+		"Revisions": newest,
+		"Expires":   info.Expires.Format(time.RFC1123),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := HTMLHistoryTemplate.Execute(w, data); err != nil {
+		sendError(w, 500, err)
+	}
+}
+
+// renderBundleHTML renders the files of a bundle as tabs with links to each
+// file and to download them all
+func renderBundleHTML(w http.ResponseWriter, r *http.Request, files []bundleView, info *PasteInfo) {
+	base := "/x/" + info.Name + "/"
+	if info.Editable && chi.URLParam(r, "rev") != "" {
+		base += "v/" + strconv.Itoa(info.Revision) + "/"
+	}
+	for i := range files {
+		files[i].Path = base + url.PathEscape(files[i].Name)
+	}
+
+	data := map[string]interface{}{
+		"Name":     info.Name,
+		"Files":    files,
+		"Expires":  info.Expires.Format(time.RFC1123),
+		"Parent":   info.Parent,
+		"Revision": 0,
+	}
+	if info.Editable {
+		data["Revision"] = info.Revision
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := HTMLBundleTemplate.Execute(w, data); err != nil {
+		sendError(w, 500, err)
+	}
+}
+
+// diffRow is a row of a side by side diff. Lines which only exist on one side
+// leave the other empty
+type diffRow struct {
+	A, B         int
+	AHTML, BHTML template.HTML
+	AOp, BOp     string
+	// Gap marks the row between two hunks standing in for the unchanged lines
+	// which are not shown
+	Gap bool
+}
+
+// renderDiffHTML renders two pastes side by side with their changed lines and
+// a few unchanged lines around them highlighted. Pastes which are not text
+// have no lines and are only said to differ
+func renderDiffHTML(w http.ResponseWriter, r *http.Request, a, b *comparedPaste, lines []diff.Line) {
+	data := map[string]interface{}{
+		"A":         a,
+		"B":         b,
+		"Binary":    lines == nil,
+		"Identical": string(a.Body) == string(b.Body),
+		"Language":  "",
+	}
+
+	if lines != nil {
+		var lang *highlight.Language
+		switch {
+		case r.URL.Query().Get("lang") != "":
+			lang = highlight.Lookup(r.URL.Query().Get("lang"))
+		case a.Info.Language != "":
+			lang = highlight.Lookup(a.Info.Language)
+		default:
+			lang = highlight.Detect(string(a.Body))
+		}
+		if lang != nil {
+			data["Language"] = lang.Name
+		}
+		data["Rows"] = diffRows(diff.Hunks(lines, diff.DefaultContext),
+			highlight.Lines(lang, string(a.Body)), highlight.Lines(lang, string(b.Body)))
+		data["Languages"] = highlight.Names()
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := HTMLDiffTemplate.Execute(w, data); err != nil {
+		sendError(w, 500, err)
+	}
+}
+
+// diffRows lays out hunks side by side using the highlighted lines of each
+// paste. Deleted lines are paired with the lines inserted in their place
+func diffRows(hunks []diff.Hunk, aHTML, bHTML []template.HTML) []diffRow {
+	var rows []diffRow
+	var dels, ins []diff.Line
+	flush := func() {
+		for i := 0; i < len(dels) || i < len(ins); i++ {
+			row := diffRow{AOp: "diff-empty", BOp: "diff-empty"}
+			if i < len(dels) {
+				row.A, row.AHTML, row.AOp = dels[i].A, lineHTML(aHTML, dels[i].A), "diff-del"
+			}
+			if i < len(ins) {
+				row.B, row.BHTML, row.BOp = ins[i].B, lineHTML(bHTML, ins[i].B), "diff-ins"
+			}
+			rows = append(rows, row)
+		}
+		dels, ins = nil, nil
+	}
+
+	for i, h := range hunks {
+		if i > 0 || h.A > 1 || h.B > 1 {
+			rows = append(rows, diffRow{Gap: true})
+		}
+		for _, l := range h.Lines {
+			switch l.Op {
+			case diff.Delete:
+				dels = append(dels, l)
+			case diff.Insert:
+				ins = append(ins, l)
+			default:
+				flush()
+				rows = append(rows, diffRow{
+					A: l.A, AHTML: lineHTML(aHTML, l.A),
+					B: l.B, BHTML: lineHTML(bHTML, l.B),
+				})
+			}
+		}
+		flush()
+	}
+	return rows
+}
+
+// lineHTML returns the highlighted line numbered n counting from 1, or nothing
+// if the highlighter split the text into fewer lines than the diff did
+func lineHTML(lines []template.HTML, n int) template.HTML {
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	return lines[n-1]
+}
+
+// renderPasswordHTML renders a page which asks for the password of a protected
+// paste and posts it back to the same URL
+func renderPasswordHTML(w http.ResponseWriter, info *PasteInfo, wrong bool) {
+	data := map[string]interface{}{
+		"Name":  info.Name,
+		"Wrong": wrong,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusUnauthorized)
+	if err := HTMLPasswordTemplate.Execute(w, data); err != nil {
+		log.WithError(err).Error("failed to render password prompt")
+	}
+}